@@ -6,6 +6,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+
+	"github.com/alexbakker/tox4go/internal/util"
 )
 
 type PacketType byte
@@ -21,6 +23,15 @@ const (
 
 var ErrUnknownPacketType = errors.New("unknown packet type")
 
+var (
+	// ErrTruncated is returned when decoding data that ends before all fields
+	// could be read.
+	ErrTruncated = util.ErrTruncated
+	// ErrMalformed is returned when decoding data with a field that has an
+	// invalid value.
+	ErrMalformed = util.ErrMalformed
+)
+
 type Packet interface {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
@@ -66,14 +77,13 @@ func (p *RawPacket) MarshalBinary() ([]byte, error) {
 func (p *RawPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
-	err := binary.Read(reader, binary.BigEndian, &p.Type)
+	err := util.ReadBinary(reader, binary.BigEndian, &p.Type)
 	if err != nil {
 		return err
 	}
 
 	p.Payload = make([]byte, reader.Len())
-	_, err = reader.Read(p.Payload)
-	return err
+	return util.ReadFull(reader, p.Payload)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
@@ -102,17 +112,17 @@ func (p *InfoResponsePacket) MarshalBinary() ([]byte, error) {
 func (p *InfoResponsePacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
-	err := binary.Read(reader, binary.BigEndian, &p.Version)
+	err := util.ReadBinary(reader, binary.BigEndian, &p.Version)
 	if err != nil {
 		return err
 	}
 
 	motdBytes := make([]byte, reader.Len())
 	if len(motdBytes) > maxMOTDLength {
-		return errors.New("MOTD too long")
+		return fmt.Errorf("%w: MOTD too long", ErrMalformed)
 	}
 
-	_, err = reader.Read(motdBytes)
+	err = util.ReadFull(reader, motdBytes)
 	if err != nil {
 		return err
 	}
@@ -145,7 +155,7 @@ func (p *InfoRequestPacket) UnmarshalBinary(data []byte) error {
 	expected := requestPacketLength - 1
 
 	if dataLen != expected {
-		return fmt.Errorf("invalid packet length: %d, expected: %d", dataLen, expected)
+		return fmt.Errorf("%w: invalid packet length: %d, expected: %d", ErrMalformed, dataLen, expected)
 	}
 
 	return nil
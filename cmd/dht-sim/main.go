@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/dht/dhtsim"
+	"github.com/alexbakker/tox4go/transport/simnet"
+)
+
+var (
+	nodes          = flag.Int("nodes", dhtsim.DefaultNodes, "amount of nodes")
+	bootstrapNodes = flag.Int("bootstrap-nodes", dhtsim.DefaultBootstrapNodes, "amount of bootstrap nodes")
+	lookups        = flag.Int("lookups", 200, "amount of lookups to run")
+	parallelism    = flag.Int("parallelism", dhtsim.DefaultParallelism, "amount of nodes to bootstrap and lookups to run at the same time")
+	seed           = flag.Int64("seed", 0, "seed for random decisions (results still vary between runs)")
+	latency        = flag.Duration("latency", 0, "link latency")
+	jitter         = flag.Duration("jitter", 0, "maximum random extra link latency")
+	loss           = flag.Float64("loss", 0, "packet loss rate")
+	duplicate      = flag.Float64("duplicate", 0, "packet duplication rate")
+	natRate        = flag.Float64("nat", 0, "fraction of nodes behind a port restricted cone NAT")
+	alpha          = flag.Int("alpha", dht.DefaultLookupAlpha, "amount of parallel queries per lookup")
+	k              = flag.Int("k", dht.DefaultLookupK, "amount of closest nodes a lookup converges on")
+	queryTimeout   = flag.Duration("query-timeout", dht.DefaultQueryTimeout, "timeout of a single query")
+)
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	lookupOpts := dht.LookupOptions{
+		Alpha:        *alpha,
+		K:            *k,
+		QueryTimeout: *queryTimeout,
+	}
+
+	sim, err := dhtsim.New(dhtsim.Options{
+		Nodes:          *nodes,
+		BootstrapNodes: *bootstrapNodes,
+		Parallelism:    *parallelism,
+		Seed:           *seed,
+		Conditions: simnet.Conditions{
+			Latency:       *latency,
+			Jitter:        *jitter,
+			LossRate:      *loss,
+			DuplicateRate: *duplicate,
+		},
+		NATRate:   *natRate,
+		Client:    dht.ClientOptions{Timeout: *queryTimeout},
+		Bootstrap: dht.BootstrapOptions{Lookup: lookupOpts},
+	})
+	if err != nil {
+		return err
+	}
+	defer sim.Close()
+
+	bootstrapReport, err := sim.Bootstrap(context.Background())
+	if err != nil {
+		return fmt.Errorf("bootstrap: %w", err)
+	}
+	fmt.Println(bootstrapReport)
+
+	lookupReport, err := sim.RunLookups(context.Background(), *lookups, lookupOpts)
+	if err != nil {
+		return fmt.Errorf("lookups: %w", err)
+	}
+	fmt.Println(lookupReport)
+
+	return nil
+}
@@ -18,16 +18,21 @@ type (
 
 // stateJSON is a JSON-friendly version of the state.State struct
 type stateJSON struct {
-	PublicKey     string           `json:"public_key"`
-	SecretKey     string           `json:"secret_key"`
-	Nospam        uint32           `json:"nospam"`
-	Name          string           `json:"name"`
-	StatusMessage string           `json:"status_message"`
-	Status        state.UserStatus `json:"status"`
-	Friends       []*friendJSON    `json:"friends"`
-	Nodes         []*nodeJSON      `json:"nodes"`
-	TCPRelays     []*nodeJSON      `json:"tcp_relays"`
-	PathNodes     []*nodeJSON      `json:"path_nodes"`
+	PublicKey     string            `json:"public_key"`
+	SecretKey     string            `json:"secret_key"`
+	Nospam        uint32            `json:"nospam"`
+	Name          string            `json:"name"`
+	StatusMessage string            `json:"status_message"`
+	Status        state.UserStatus  `json:"status"`
+	Friends       []*friendJSON     `json:"friends"`
+	Nodes         []*nodeJSON       `json:"nodes"`
+	TCPRelays     []*nodeJSON       `json:"tcp_relays"`
+	PathNodes     []*nodeJSON       `json:"path_nodes"`
+	Conferences   []*conferenceJSON `json:"conferences"`
+	Groups        []*groupJSON      `json:"groups"`
+
+	UnknownSections    []*sectionJSON `json:"unknown_sections,omitempty"`
+	UnknownDHTSections []*sectionJSON `json:"unknown_dht_sections,omitempty"`
 }
 
 // friendJSON is a JSON-friendly version of the state.Friend struct
@@ -42,6 +47,86 @@ type friendJSON struct {
 	LastSeen       uint64             `json:"last_seen"`
 }
 
+// conferenceJSON is a JSON-friendly version of the state.Conference struct
+type conferenceJSON struct {
+	Type               state.ConferenceType  `json:"type"`
+	ID                 string                `json:"id"`
+	MessageNumber      uint32                `json:"message_number"`
+	LossyMessageNumber uint16                `json:"lossy_message_number"`
+	PeerNumber         uint16                `json:"peer_number"`
+	Title              string                `json:"title"`
+	Peers              []*conferencePeerJSON `json:"peers"`
+}
+
+// conferencePeerJSON is a JSON-friendly version of the state.ConferencePeer
+// struct
+type conferencePeerJSON struct {
+	PublicKey     string `json:"public_key"`
+	TempPublicKey string `json:"temp_public_key"`
+	PeerNumber    uint16 `json:"peer_number"`
+	LastActive    uint64 `json:"last_active"`
+	Nick          string `json:"nick"`
+}
+
+// groupJSON is a JSON-friendly version of the state.Group struct
+type groupJSON struct {
+	Disconnected         bool                 `json:"disconnected"`
+	SharedState          groupSharedStateJSON `json:"shared_state"`
+	SharedStateSignature string               `json:"shared_state_signature"`
+	Topic                groupTopicJSON       `json:"topic"`
+	Moderators           []string             `json:"moderators"`
+	ChatPublicKey        string               `json:"chat_public_key"`
+	ChatSecretKey        string               `json:"chat_secret_key"`
+	SelfPublicKey        string               `json:"self_public_key"`
+	SelfSecretKey        string               `json:"self_secret_key"`
+	Self                 groupSelfJSON        `json:"self"`
+	SavedPeers           groupSavedPeersJSON  `json:"saved_peers"`
+}
+
+// groupSharedStateJSON is a JSON-friendly version of the
+// state.GroupSharedState struct
+type groupSharedStateJSON struct {
+	FounderPublicKey string                  `json:"founder_public_key"`
+	MaxPeers         uint16                  `json:"max_peers"`
+	Name             string                  `json:"name"`
+	PrivacyState     state.GroupPrivacyState `json:"privacy_state"`
+	Password         string                  `json:"password"`
+	ModListHash      string                  `json:"mod_list_hash"`
+	Version          uint32                  `json:"version"`
+	TopicLock        state.GroupTopicLock    `json:"topic_lock"`
+	VoiceState       state.GroupVoiceState   `json:"voice_state"`
+}
+
+// groupTopicJSON is a JSON-friendly version of the state.GroupTopic struct
+type groupTopicJSON struct {
+	Version   uint32 `json:"version"`
+	Checksum  uint16 `json:"checksum"`
+	Topic     string `json:"topic"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// groupSelfJSON is a JSON-friendly version of the state.GroupSelf struct
+type groupSelfJSON struct {
+	Nick   string           `json:"nick"`
+	Role   state.GroupRole  `json:"role"`
+	Status state.UserStatus `json:"status"`
+}
+
+// groupSavedPeersJSON is a JSON-friendly version of the state.GroupSavedPeers
+// struct
+type groupSavedPeersJSON struct {
+	Count uint16 `json:"count"`
+	Data  string `json:"data"`
+}
+
+// sectionJSON is a JSON-friendly version of the state.Section struct
+type sectionJSON struct {
+	Type   uint16 `json:"type"`
+	Body   string `json:"body"`
+	Before uint16 `json:"before,omitempty"`
+}
+
 // nodeJSON is a JSON-friendly version of the dht.Node struct
 type nodeJSON struct {
 	Type      dht.NodeType `json:"type"`
@@ -62,6 +147,11 @@ func (s *stateAlias) MarshalJSON() ([]byte, error) {
 		Nodes:         convertNodes(s.Nodes),
 		TCPRelays:     convertNodes(s.TCPRelays),
 		PathNodes:     convertNodes(s.PathNodes),
+		Conferences:   convertConferences(s.Conferences),
+		Groups:        convertGroups(s.Groups),
+
+		UnknownSections:    convertSections(s.UnknownSections),
+		UnknownDHTSections: convertSections(s.UnknownDHTSections),
 	})
 }
 
@@ -102,6 +192,30 @@ func (s *stateAlias) UnmarshalJSON(data []byte) error {
 	}
 	s.TCPRelays = tcpRelays
 
+	conferences, err := convertConferencesBack(temp.Conferences)
+	if err != nil {
+		return err
+	}
+	s.Conferences = conferences
+
+	groups, err := convertGroupsBack(temp.Groups)
+	if err != nil {
+		return err
+	}
+	s.Groups = groups
+
+	unknownSections, err := convertSectionsBack(temp.UnknownSections)
+	if err != nil {
+		return err
+	}
+	s.UnknownSections = unknownSections
+
+	unknownDHTSections, err := convertSectionsBack(temp.UnknownDHTSections)
+	if err != nil {
+		return err
+	}
+	s.UnknownDHTSections = unknownDHTSections
+
 	publicKey, err := hex.DecodeString(temp.PublicKey)
 	if err != nil {
 		return err
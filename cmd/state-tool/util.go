@@ -98,3 +98,274 @@ func convertNodesBack(n1 []*nodeJSON) ([]*dht.Node, error) {
 
 	return nodes, nil
 }
+
+func convertConferences(c1 []*state.Conference) []*conferenceJSON {
+	conferences := make([]*conferenceJSON, len(c1))
+
+	for i, c := range c1 {
+		peers := make([]*conferencePeerJSON, len(c.Peers))
+		for j, p := range c.Peers {
+			peers[j] = &conferencePeerJSON{
+				PublicKey:     hex.EncodeToString(p.PublicKey[:]),
+				TempPublicKey: hex.EncodeToString(p.TempPublicKey[:]),
+				PeerNumber:    p.PeerNumber,
+				LastActive:    p.LastActive,
+				Nick:          p.Nick,
+			}
+		}
+
+		conferences[i] = &conferenceJSON{
+			Type:               c.Type,
+			ID:                 hex.EncodeToString(c.ID[:]),
+			MessageNumber:      c.MessageNumber,
+			LossyMessageNumber: c.LossyMessageNumber,
+			PeerNumber:         c.PeerNumber,
+			Title:              c.Title,
+			Peers:              peers,
+		}
+	}
+
+	return conferences
+}
+
+func convertConferencesBack(c1 []*conferenceJSON) ([]*state.Conference, error) {
+	conferences := make([]*state.Conference, len(c1))
+
+	for i, c := range c1 {
+		conferences[i] = &state.Conference{
+			Type:               c.Type,
+			MessageNumber:      c.MessageNumber,
+			LossyMessageNumber: c.LossyMessageNumber,
+			PeerNumber:         c.PeerNumber,
+			Title:              c.Title,
+		}
+
+		id, err := hex.DecodeString(c.ID)
+		if err != nil {
+			return nil, err
+		} else if len(id) != state.ConferenceIDSize {
+			return nil, errorKeyLength{
+				kind:     "conference id",
+				expected: state.ConferenceIDSize,
+				actual:   len(id),
+			}
+		}
+		conferences[i].ID = (*[state.ConferenceIDSize]byte)(id)
+
+		for _, p := range c.Peers {
+			peer := &state.ConferencePeer{
+				PeerNumber: p.PeerNumber,
+				LastActive: p.LastActive,
+				Nick:       p.Nick,
+			}
+
+			publicKey, err := hex.DecodeString(p.PublicKey)
+			if err != nil {
+				return nil, err
+			} else if len(publicKey) != crypto.PublicKeySize {
+				return nil, errorKeyLength{
+					kind:     "public",
+					expected: crypto.PublicKeySize,
+					actual:   len(publicKey),
+				}
+			}
+			peer.PublicKey = (*[crypto.PublicKeySize]byte)(publicKey)
+
+			tempPublicKey, err := hex.DecodeString(p.TempPublicKey)
+			if err != nil {
+				return nil, err
+			} else if len(tempPublicKey) != crypto.PublicKeySize {
+				return nil, errorKeyLength{
+					kind:     "temporary public",
+					expected: crypto.PublicKeySize,
+					actual:   len(tempPublicKey),
+				}
+			}
+			peer.TempPublicKey = (*[crypto.PublicKeySize]byte)(tempPublicKey)
+
+			conferences[i].Peers = append(conferences[i].Peers, peer)
+		}
+	}
+
+	return conferences, nil
+}
+
+func convertGroups(g1 []*state.Group) []*groupJSON {
+	groups := make([]*groupJSON, len(g1))
+
+	for i, g := range g1 {
+		mods := make([]string, len(g.Moderators))
+		for j, mod := range g.Moderators {
+			mods[j] = hex.EncodeToString(mod[:])
+		}
+
+		groups[i] = &groupJSON{
+			Disconnected: g.Disconnected,
+			SharedState: groupSharedStateJSON{
+				FounderPublicKey: hex.EncodeToString(g.SharedState.FounderPublicKey[:]),
+				MaxPeers:         g.SharedState.MaxPeers,
+				Name:             g.SharedState.Name,
+				PrivacyState:     g.SharedState.PrivacyState,
+				Password:         g.SharedState.Password,
+				ModListHash:      hex.EncodeToString(g.SharedState.ModListHash[:]),
+				Version:          g.SharedState.Version,
+				TopicLock:        g.SharedState.TopicLock,
+				VoiceState:       g.SharedState.VoiceState,
+			},
+			SharedStateSignature: hex.EncodeToString(g.SharedStateSignature[:]),
+			Topic: groupTopicJSON{
+				Version:   g.Topic.Version,
+				Checksum:  g.Topic.Checksum,
+				Topic:     g.Topic.Topic,
+				PublicKey: hex.EncodeToString(g.Topic.PublicKey[:]),
+				Signature: hex.EncodeToString(g.Topic.Signature[:]),
+			},
+			Moderators:    mods,
+			ChatPublicKey: hex.EncodeToString(g.ChatPublicKey[:]),
+			ChatSecretKey: hex.EncodeToString(g.ChatSecretKey[:]),
+			SelfPublicKey: hex.EncodeToString(g.SelfPublicKey[:]),
+			SelfSecretKey: hex.EncodeToString(g.SelfSecretKey[:]),
+			Self: groupSelfJSON{
+				Nick:   g.Self.Nick,
+				Role:   g.Self.Role,
+				Status: g.Self.Status,
+			},
+			SavedPeers: groupSavedPeersJSON{
+				Count: g.SavedPeers.Count,
+				Data:  hex.EncodeToString(g.SavedPeers.Data),
+			},
+		}
+	}
+
+	return groups
+}
+
+func convertGroupsBack(g1 []*groupJSON) ([]*state.Group, error) {
+	groups := make([]*state.Group, len(g1))
+
+	for i, g := range g1 {
+		group := &state.Group{
+			Disconnected: g.Disconnected,
+			SharedState: state.GroupSharedState{
+				FounderPublicKey: new([state.GroupExtPublicKeySize]byte),
+				MaxPeers:         g.SharedState.MaxPeers,
+				Name:             g.SharedState.Name,
+				PrivacyState:     g.SharedState.PrivacyState,
+				Password:         g.SharedState.Password,
+				ModListHash:      new([state.GroupModListHashSize]byte),
+				Version:          g.SharedState.Version,
+				TopicLock:        g.SharedState.TopicLock,
+				VoiceState:       g.SharedState.VoiceState,
+			},
+			SharedStateSignature: new([state.GroupSignatureSize]byte),
+			Topic: state.GroupTopic{
+				Version:   g.Topic.Version,
+				Checksum:  g.Topic.Checksum,
+				Topic:     g.Topic.Topic,
+				PublicKey: new([state.GroupSigPublicKeySize]byte),
+				Signature: new([state.GroupSignatureSize]byte),
+			},
+			ChatPublicKey: new([state.GroupExtPublicKeySize]byte),
+			ChatSecretKey: new([state.GroupExtSecretKeySize]byte),
+			SelfPublicKey: new([state.GroupExtPublicKeySize]byte),
+			SelfSecretKey: new([state.GroupExtSecretKeySize]byte),
+			Self: state.GroupSelf{
+				Nick:   g.Self.Nick,
+				Role:   g.Self.Role,
+				Status: g.Self.Status,
+			},
+			SavedPeers: state.GroupSavedPeers{Count: g.SavedPeers.Count},
+		}
+
+		for _, field := range []struct {
+			kind string
+			src  string
+			dst  []byte
+		}{
+			{"founder public", g.SharedState.FounderPublicKey, group.SharedState.FounderPublicKey[:]},
+			{"moderator list hash", g.SharedState.ModListHash, group.SharedState.ModListHash[:]},
+			{"shared state signature", g.SharedStateSignature, group.SharedStateSignature[:]},
+			{"topic public", g.Topic.PublicKey, group.Topic.PublicKey[:]},
+			{"topic signature", g.Topic.Signature, group.Topic.Signature[:]},
+			{"chat public", g.ChatPublicKey, group.ChatPublicKey[:]},
+			{"chat secret", g.ChatSecretKey, group.ChatSecretKey[:]},
+			{"self public", g.SelfPublicKey, group.SelfPublicKey[:]},
+			{"self secret", g.SelfSecretKey, group.SelfSecretKey[:]},
+		} {
+			if err := decodeHexKey(field.kind, field.src, field.dst); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, mod := range g.Moderators {
+			key := new([state.GroupSigPublicKeySize]byte)
+			if err := decodeHexKey("moderator public", mod, key[:]); err != nil {
+				return nil, err
+			}
+			group.Moderators = append(group.Moderators, key)
+		}
+
+		if g.SavedPeers.Data != "" {
+			data, err := hex.DecodeString(g.SavedPeers.Data)
+			if err != nil {
+				return nil, err
+			}
+			group.SavedPeers.Data = data
+		}
+
+		groups[i] = group
+	}
+
+	return groups, nil
+}
+
+// decodeHexKey decodes the given hex string into dst, which must be of the
+// exact same size as the decoded data.
+func decodeHexKey(kind string, src string, dst []byte) error {
+	key, err := hex.DecodeString(src)
+	if err != nil {
+		return err
+	} else if len(key) != len(dst) {
+		return errorKeyLength{
+			kind:     kind,
+			expected: len(dst),
+			actual:   len(key),
+		}
+	}
+
+	copy(dst, key)
+	return nil
+}
+
+func convertSections(s1 []*state.Section) []*sectionJSON {
+	sections := make([]*sectionJSON, len(s1))
+
+	for i, s := range s1 {
+		sections[i] = &sectionJSON{
+			Type:   s.Type,
+			Body:   hex.EncodeToString(s.Body),
+			Before: s.Before,
+		}
+	}
+
+	return sections
+}
+
+func convertSectionsBack(s1 []*sectionJSON) ([]*state.Section, error) {
+	sections := make([]*state.Section, len(s1))
+
+	for i, s := range s1 {
+		body, err := hex.DecodeString(s.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		sections[i] = &state.Section{
+			Type:   s.Type,
+			Body:   body,
+			Before: s.Before,
+		}
+	}
+
+	return sections, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 
+	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -64,3 +65,12 @@ func PrecomputeKey(publicKey *[PublicKeySize]byte, secretKey *[SecretKeySize]byt
 func GenerateKeyPair() (*[PublicKeySize]byte, *[SecretKeySize]byte, error) {
 	return box.GenerateKey(rand.Reader)
 }
+
+// DerivePublicKey calculates the curve25519 public key that belongs to the
+// given secret key.
+func DerivePublicKey(secretKey *[SecretKeySize]byte) *[PublicKeySize]byte {
+	publicKey := new([PublicKeySize]byte)
+	curve25519.ScalarBaseMult(publicKey, secretKey)
+
+	return publicKey
+}
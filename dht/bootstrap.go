@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultBootstrapMinNodes is the default amount of nodes the routing table
+	// should contain for bootstrapping to be considered successful.
+	DefaultBootstrapMinNodes = 16
+
+	// DefaultBootstrapMaxLookups is the default maximum amount of lookups to
+	// perform while bootstrapping.
+	DefaultBootstrapMaxLookups = 8
+)
+
+// ErrBootstrapFailed is returned by Bootstrap if none of the given nodes
+// responded.
+var ErrBootstrapFailed = errors.New("bootstrap failed: no nodes responded")
+
+type BootstrapOptions struct {
+	// MinNodes is the amount of nodes the routing table should contain for
+	// bootstrapping to be considered done. If zero, DefaultBootstrapMinNodes is
+	// used.
+	MinNodes int
+	// MaxLookups is the maximum amount of lookups to perform. If zero,
+	// DefaultBootstrapMaxLookups is used.
+	MaxLookups int
+	// Lookup contains the options for the lookups that are performed.
+	Lookup LookupOptions
+}
+
+// Bootstrap seeds the routing table of the server with the given nodes. These
+// can be obtained from toxstatus.GetNodes or from the Nodes field of a
+// state.State, for example. TCP nodes without a transport address are ignored.
+// The given nodes are pinged first, after which they are queried for our own
+// public key (and random ones if that isn't enough), until the routing table
+// contains enough nodes that have responded to us. It is not an error if the
+// routing table ends up with less than the requested amount of nodes, as long
+// as at least one of the given nodes responded.
+func (s *Server) Bootstrap(ctx context.Context, nodes []*Node, opts BootstrapOptions) error {
+	minNodes := opts.MinNodes
+	if minNodes <= 0 {
+		minNodes = DefaultBootstrapMinNodes
+	}
+
+	maxLookups := opts.MaxLookups
+	if maxLookups <= 0 {
+		maxLookups = DefaultBootstrapMaxLookups
+	}
+
+	queryTimeout := opts.Lookup.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = DefaultQueryTimeout
+	}
+
+	var wg sync.WaitGroup
+	var responded atomic.Int32
+	for _, node := range nodes {
+		if !node.reachable() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+
+			pctx, cancel := context.WithTimeout(ctx, queryTimeout)
+			defer cancel()
+
+			// Nodes that respond are added to the routing table by HandlePacket
+			if _, err := s.client.Ping(pctx, node); err == nil {
+				responded.Add(1)
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// The routing table may already contain nodes, so only the given nodes
+	// count towards success
+	if responded.Load() == 0 {
+		return ErrBootstrapFailed
+	}
+
+	target := s.ident.PublicKey
+	for i := 0; i < maxLookups && s.table.Len() < minNodes; i++ {
+		// Look for our own public key first to find the nodes closest to us.
+		// Any subsequent lookups use random public keys to fill the rest of
+		// the routing table.
+		if i > 0 {
+			target = new(PublicKey)
+			if _, err := rand.Read(target[:]); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.Lookup(ctx, target, opts.Lookup); err != nil && !errors.Is(err, ErrNoNodes) {
+			return fmt.Errorf("bootstrap lookup: %w", err)
+		}
+	}
+
+	return nil
+}
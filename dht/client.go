@@ -0,0 +1,216 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alexbakker/tox4go/dht/ping"
+	"github.com/alexbakker/tox4go/transport"
+)
+
+// Client sends requests to other nodes in the DHT and waits for their
+// responses. Responses are matched to requests by public key and ping ID. It is
+// safe for concurrent use.
+//
+// A Client only handles response packets. To also answer requests from other
+// nodes, use a Server, which has a Client of its own.
+type Client struct {
+	ident     *Identity
+	transport transport.Transport
+	timeout   time.Duration
+
+	// mu guards pings and waiters. The ping set tracks the outstanding
+	// requests, and the response to a request is delivered to its waiter.
+	mu      sync.Mutex
+	pings   *ping.Set
+	waiters map[*ping.Ping]chan Packet
+}
+
+type ClientOptions struct {
+	// Timeout is the maximum amount of time to wait for a response to a
+	// request. If zero, ping.DefaultTimeout is used. A shorter deadline can be
+	// set for individual requests through their context.
+	Timeout time.Duration
+}
+
+// NewClient creates a new DHT client for the given identity.
+func NewClient(ident *Identity, opts ClientOptions) *Client {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = ping.DefaultTimeout
+	}
+
+	return &Client{
+		ident:   ident,
+		timeout: timeout,
+		pings:   ping.NewSet(timeout),
+		waiters: make(map[*ping.Ping]chan Packet),
+	}
+}
+
+// SetTransport sets the transport used to send packets. It must be called
+// before the transport starts listening for packets.
+func (c *Client) SetTransport(t transport.Transport) {
+	c.transport = t
+}
+
+// Ping sends a ping request to the given node and waits for the response. It
+// returns the round-trip time.
+func (c *Client) Ping(ctx context.Context, node *Node) (time.Duration, error) {
+	start := time.Now()
+	packet, err := c.request(ctx, node, func(pingID uint64) Packet {
+		return &PingRequestPacket{PingID: pingID}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := packet.(*PingResponsePacket); !ok {
+		return 0, fmt.Errorf("unexpected response packet: %s", packet.ID())
+	}
+
+	return time.Since(start), nil
+}
+
+// GetNodes sends a get nodes request for the given target public key to the
+// given node and waits for the response. It returns the nodes the node sent
+// back to us.
+func (c *Client) GetNodes(ctx context.Context, node *Node, target *PublicKey) ([]*Node, error) {
+	packet, err := c.request(ctx, node, func(pingID uint64) Packet {
+		return &GetNodesPacket{PublicKey: target, PingID: pingID}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, ok := packet.(*SendNodesPacket)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response packet: %s", packet.ID())
+	}
+
+	return res.Nodes, nil
+}
+
+// HandlePacket implements transport.PacketHandler. Packets that are not valid
+// DHT response packets addressed to us are dropped.
+func (c *Client) HandlePacket(data []byte, addr net.Addr) {
+	var encPacket EncryptedPacket
+	if err := encPacket.UnmarshalBinary(data); err != nil {
+		return
+	}
+
+	packet, err := c.ident.DecryptPacket(&encPacket)
+	if err != nil {
+		return
+	}
+
+	c.handleResponse(encPacket.SenderPublicKey, packet)
+}
+
+// SendPacket encrypts the given packet for the given node and sends it.
+func (c *Client) SendPacket(packet Packet, node *Node) error {
+	encPacket, err := c.ident.EncryptPacket(packet, node.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := encPacket.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return c.transport.SendPacket(data, node.Addr())
+}
+
+// handleResponse delivers the given decrypted response packet to the request
+// it belongs to. It returns whether the packet was a response to one of our
+// requests.
+func (c *Client) handleResponse(publicKey *PublicKey, packet Packet) bool {
+	var pingID uint64
+	switch p := packet.(type) {
+	case *PingResponsePacket:
+		pingID = p.PingID
+	case *SendNodesPacket:
+		pingID = p.PingID
+	default:
+		return false
+	}
+
+	return c.resolveRequest(publicKey, pingID, packet) == nil
+}
+
+// request sends the packet returned by newPacket to the given node and waits
+// for the response.
+func (c *Client) request(ctx context.Context, node *Node, newPacket func(pingID uint64) Packet) (Packet, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	p, ch, err := c.addRequest(node.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	defer c.removeRequest(node.PublicKey, p)
+
+	if err = c.SendPacket(newPacket(p.ID()), node); err != nil {
+		return nil, err
+	}
+
+	select {
+	case packet := <-ch:
+		return packet, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// addRequest registers a new request to the node with the given public key in
+// the ping set. The response is delivered on the returned channel. The caller
+// must call removeRequest once it is no longer interested in the response.
+func (c *Client) addRequest(publicKey *PublicKey) (*ping.Ping, <-chan Packet, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, err := c.pings.Add(publicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan Packet, 1)
+	c.waiters[p] = ch
+	return p, ch, nil
+}
+
+// removeRequest stops tracking the given request.
+func (c *Client) removeRequest(publicKey *PublicKey, p *ping.Ping) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _ = c.pings.Pop(publicKey, p.ID())
+	delete(c.waiters, p)
+}
+
+// resolveRequest delivers the given response packet to the request it belongs
+// to. It returns an error if there is no outstanding request for the given
+// public key and ping ID.
+func (c *Client) resolveRequest(publicKey *PublicKey, pingID uint64, packet Packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, err := c.pings.Pop(publicKey, pingID)
+	if err != nil {
+		return err
+	}
+
+	ch, ok := c.waiters[p]
+	if !ok {
+		return errors.New("no waiter for request")
+	}
+
+	delete(c.waiters, p)
+	ch <- packet
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package dhtkey defines the public key type of DHT nodes. It's a separate
+// package, so that the packages that the dht package depends on can use it as
+// well.
+package dhtkey
+
+import (
+	"encoding/hex"
+
+	"github.com/alexbakker/tox4go/crypto"
+)
+
+// Size is the size of a public key in bytes.
+const Size = crypto.PublicKeySize
+
+// PublicKey is the public key of a node in the DHT.
+type PublicKey [Size]byte
+
+func (pk *PublicKey) Closest(pk1 *PublicKey, pk2 *PublicKey) *PublicKey {
+	// Return pk1 in case both public keys are the same distance away from the
+	// target public key
+	if pk.CompareDistance(pk1, pk2) <= 0 {
+		return pk1
+	}
+
+	return pk2
+}
+
+// CompareDistance returns -1 if pk1 is closer to pk than pk2, 1 if pk2 is
+// closer and 0 if both are the same distance away.
+func (pk *PublicKey) CompareDistance(pk1 *PublicKey, pk2 *PublicKey) int {
+	for i := 0; i < Size; i++ {
+		dist1 := pk[i] ^ pk1[i]
+		dist2 := pk[i] ^ pk2[i]
+
+		if dist1 < dist2 {
+			return -1
+		}
+
+		if dist1 > dist2 {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func (pk1 *PublicKey) DistanceTo(pk2 *PublicKey) *[Size]byte {
+	dist := new([Size]byte)
+
+	for i := 0; i < Size; i++ {
+		dist[i] = pk1[i] ^ pk2[i]
+	}
+
+	return dist
+}
+
+func (pk *PublicKey) String() string {
+	return hex.EncodeToString(pk[:])
+}
@@ -0,0 +1,370 @@
+// Package dhtsim implements a harness that runs a DHT of in-process nodes on
+// top of a simulated network. It is used to measure how well routing changes
+// converge before they are deployed to real nodes.
+//
+// A simulation consists of a small set of well-known bootstrap nodes that know
+// about each other, and a large amount of regular nodes that join the DHT
+// through them. After bootstrapping, lookups for the public keys of random
+// nodes are run across the DHT to measure the lookup success rate, and the
+// amount of hops and messages per lookup.
+package dhtsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/transport/simnet"
+)
+
+const (
+	// DefaultNodes is the default amount of nodes in a simulation.
+	DefaultNodes = 200
+
+	// DefaultBootstrapNodes is the default amount of well-known bootstrap
+	// nodes in a simulation.
+	DefaultBootstrapNodes = 4
+
+	// DefaultParallelism is the default amount of nodes that bootstrap, or
+	// lookups that run, at the same time.
+	DefaultParallelism = 16
+)
+
+type Options struct {
+	// Nodes is the total amount of nodes in the simulation, including the
+	// bootstrap nodes. If zero, DefaultNodes is used.
+	Nodes int
+	// BootstrapNodes is the amount of well-known bootstrap nodes. If zero,
+	// DefaultBootstrapNodes is used.
+	BootstrapNodes int
+	// Parallelism is the amount of nodes that bootstrap at the same time, and
+	// the amount of lookups that run at the same time. If zero,
+	// DefaultParallelism is used.
+	Parallelism int
+	// Seed is the seed for the random decisions of the simulation and the
+	// simulated network. The simulated network doesn't run in manual mode,
+	// because the nodes wait for responses on the real clock. As a result, the
+	// results of a simulation still vary between runs with the same seed.
+	Seed int64
+	// Conditions are the link conditions of the simulated network.
+	Conditions simnet.Conditions
+	// NATRate is the fraction of regular nodes that is placed behind a NAT of
+	// type NATType, in the range [0, 1]. Bootstrap nodes are never placed
+	// behind a NAT.
+	NATRate float64
+	// NATType is the type of NAT that nodes are placed behind. If zero,
+	// simnet.NATPortRestrictedCone is used.
+	NATType simnet.NATType
+
+	// Identity contains the options for the identities of the nodes.
+	Identity dht.IdentityOptions
+	// Table contains the options for the routing tables of the nodes.
+	Table dht.RoutingTableOptions
+	// Client contains the options for the clients of the nodes.
+	Client dht.ClientOptions
+	// Bootstrap contains the options for the bootstrapping of regular nodes.
+	Bootstrap dht.BootstrapOptions
+}
+
+// Simulation is a DHT of in-process nodes connected by a simulated network.
+type Simulation struct {
+	network        *simnet.Network
+	rand           *rand.Rand
+	parallelism    int
+	bootstrapOpts  dht.BootstrapOptions
+	servers        []*dht.Server
+	endpoints      []*simnet.Endpoint
+	bootstrapNodes []*dht.Node
+}
+
+// BootstrapReport contains the results of bootstrapping a simulation.
+type BootstrapReport struct {
+	// Nodes is the amount of nodes that tried to bootstrap.
+	Nodes int
+	// Failed is the amount of nodes that failed to bootstrap.
+	Failed int
+	// MeanTableSize is the mean amount of nodes in the routing table of all
+	// nodes in the simulation after bootstrapping.
+	MeanTableSize float64
+	// Messages is the amount of packets that were sent while bootstrapping.
+	Messages uint64
+	// Duration is the amount of time bootstrapping took.
+	Duration time.Duration
+}
+
+// LookupReport contains the results of running lookups in a simulation.
+type LookupReport struct {
+	// Lookups is the amount of lookups that were run.
+	Lookups int
+	// Succeeded is the amount of lookups that found the node they were
+	// looking for.
+	Succeeded int
+	// Errors is the amount of lookups that returned an error.
+	Errors int
+	// MeanHops is the mean amount of hops per lookup.
+	MeanHops float64
+	// MaxHops is the maximum amount of hops of a single lookup.
+	MaxHops int
+	// MeanQueries is the mean amount of get nodes requests per lookup.
+	MeanQueries float64
+	// MeanMessages is the mean amount of packets that were sent per lookup,
+	// including responses.
+	MeanMessages float64
+	// Duration is the amount of time running the lookups took.
+	Duration time.Duration
+}
+
+// New creates a new simulation. The nodes start listening for packets right
+// away, but don't know about each other until Bootstrap is called.
+func New(opts Options) (*Simulation, error) {
+	nodes := opts.Nodes
+	if nodes <= 0 {
+		nodes = DefaultNodes
+	}
+
+	bootstrapNodes := opts.BootstrapNodes
+	if bootstrapNodes <= 0 {
+		bootstrapNodes = DefaultBootstrapNodes
+	}
+	if bootstrapNodes > nodes {
+		return nil, fmt.Errorf("too many bootstrap nodes: %d > %d", bootstrapNodes, nodes)
+	}
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+
+	natType := opts.NATType
+	if natType == 0 {
+		natType = simnet.NATPortRestrictedCone
+	}
+
+	s := &Simulation{
+		network: simnet.New(simnet.Options{
+			Seed:       opts.Seed,
+			Conditions: opts.Conditions,
+		}),
+		rand:          rand.New(rand.NewSource(opts.Seed)),
+		parallelism:   parallelism,
+		bootstrapOpts: opts.Bootstrap,
+	}
+
+	for i := 0; i < nodes; i++ {
+		ident, err := dht.NewIdentity(opts.Identity)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		srv := dht.NewServer(ident, dht.ServerOptions{
+			Table:  dht.NewRoutingTable(ident.PublicKey, opts.Table),
+			Client: opts.Client,
+		})
+
+		var e *simnet.Endpoint
+		if i >= bootstrapNodes && s.rand.Float64() < opts.NATRate {
+			e = s.network.NewNAT(natType).NewEndpoint(srv.HandlePacket)
+		} else {
+			e = s.network.NewEndpoint(srv.HandlePacket)
+		}
+		srv.SetTransport(e)
+		go e.Listen(context.Background())
+
+		s.servers = append(s.servers, srv)
+		s.endpoints = append(s.endpoints, e)
+	}
+
+	for i := 0; i < bootstrapNodes; i++ {
+		s.bootstrapNodes = append(s.bootstrapNodes, newNode(s.servers[i], s.endpoints[i]))
+	}
+
+	return s, nil
+}
+
+// Network returns the simulated network the nodes are connected to.
+func (s *Simulation) Network() *simnet.Network {
+	return s.network
+}
+
+// Servers returns the DHT servers of all nodes in the simulation. The bootstrap
+// nodes come first.
+func (s *Simulation) Servers() []*dht.Server {
+	return s.servers
+}
+
+// BootstrapNodes returns the well-known bootstrap nodes of the simulation.
+func (s *Simulation) BootstrapNodes() []*dht.Node {
+	return s.bootstrapNodes
+}
+
+// Bootstrap introduces the bootstrap nodes to each other, after which all
+// regular nodes bootstrap through them. Regular nodes join the DHT in batches
+// of the configured parallelism, so that later nodes have more nodes to find.
+func (s *Simulation) Bootstrap(ctx context.Context) (*BootstrapReport, error) {
+	start := time.Now()
+	sent := s.network.Stats().Sent
+
+	bootstrapServers := s.servers[:len(s.bootstrapNodes)]
+	for i, srv := range bootstrapServers {
+		for j, node := range s.bootstrapNodes {
+			if i != j {
+				srv.Table().Insert(node)
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	report := BootstrapReport{Nodes: len(s.servers) - len(bootstrapServers)}
+	err := s.run(ctx, report.Nodes, func(ctx context.Context, i int) {
+		srv := s.servers[len(bootstrapServers)+i]
+		if err := srv.Bootstrap(ctx, s.bootstrapNodes, s.bootstrapOpts); err != nil {
+			mu.Lock()
+			report.Failed++
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var tableSize int
+	for _, srv := range s.servers {
+		tableSize += srv.Table().Len()
+	}
+
+	report.MeanTableSize = float64(tableSize) / float64(len(s.servers))
+	report.Messages = s.network.Stats().Sent - sent
+	report.Duration = time.Since(start)
+	return &report, nil
+}
+
+// RunLookups runs the given amount of lookups. Every lookup is started from a
+// random node, for the public key of another random node. A lookup succeeds if
+// the closest node it returns is the node it was looking for.
+func (s *Simulation) RunLookups(ctx context.Context, count int, opts dht.LookupOptions) (*LookupReport, error) {
+	type lookup struct {
+		srv    *dht.Server
+		target *dht.PublicKey
+	}
+
+	lookups := make([]lookup, count)
+	for i := range lookups {
+		from := s.rand.Intn(len(s.servers))
+		to := s.rand.Intn(len(s.servers) - 1)
+		if to >= from {
+			to++
+		}
+
+		lookups[i] = lookup{
+			srv:    s.servers[from],
+			target: s.servers[to].Identity().PublicKey,
+		}
+	}
+
+	start := time.Now()
+	sent := s.network.Stats().Sent
+
+	var mu sync.Mutex
+	var hops, queries int
+	report := LookupReport{Lookups: count}
+	err := s.run(ctx, count, func(ctx context.Context, i int) {
+		l := lookups[i]
+		nodes, stats, err := l.srv.LookupWithStats(ctx, l.target, opts)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		hops += stats.Hops
+		queries += stats.Queries
+		report.MaxHops = max(report.MaxHops, stats.Hops)
+		if err != nil {
+			report.Errors++
+			return
+		}
+		if len(nodes) > 0 && *nodes[0].PublicKey == *l.target {
+			report.Succeeded++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		report.MeanHops = float64(hops) / float64(count)
+		report.MeanQueries = float64(queries) / float64(count)
+		report.MeanMessages = float64(s.network.Stats().Sent-sent) / float64(count)
+	}
+	report.Duration = time.Since(start)
+	return &report, nil
+}
+
+// Close disconnects all nodes from the simulated network.
+func (s *Simulation) Close() error {
+	var errs []error
+	for _, e := range s.endpoints {
+		if err := e.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SuccessRate returns the fraction of lookups that succeeded.
+func (r *LookupReport) SuccessRate() float64 {
+	if r.Lookups == 0 {
+		return 0
+	}
+
+	return float64(r.Succeeded) / float64(r.Lookups)
+}
+
+func (r *BootstrapReport) String() string {
+	return fmt.Sprintf("bootstrap: %d nodes, %d failed, %.1f mean table size, %d messages, took %s",
+		r.Nodes, r.Failed, r.MeanTableSize, r.Messages, r.Duration)
+}
+
+func (r *LookupReport) String() string {
+	return fmt.Sprintf("lookups: %d, %.1f%% success, %d errors, %.2f mean hops, %d max hops, %.1f mean queries, %.1f mean messages, took %s",
+		r.Lookups, r.SuccessRate()*100, r.Errors, r.MeanHops, r.MaxHops, r.MeanQueries, r.MeanMessages, r.Duration)
+}
+
+// run calls fn for every index in [0, n), in batches of the configured
+// parallelism. It returns early if the given context is canceled.
+func (s *Simulation) run(ctx context.Context, n int, fn func(ctx context.Context, i int)) error {
+	for batch := 0; batch < n; batch += s.parallelism {
+		var wg sync.WaitGroup
+		for i := batch; i < min(batch+s.parallelism, n); i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				fn(ctx, i)
+			}(i)
+		}
+		wg.Wait()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newNode returns the DHT node for the given server and the endpoint it's
+// listening on.
+func newNode(srv *dht.Server, e *simnet.Endpoint) *dht.Node {
+	addr := e.LocalAddr().(*net.UDPAddr)
+	return &dht.Node{
+		Type:      dht.NodeTypeUDPIP4,
+		PublicKey: srv.Identity().PublicKey,
+		IP:        addr.IP,
+		Port:      addr.Port,
+	}
+}
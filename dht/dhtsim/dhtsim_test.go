@@ -0,0 +1,45 @@
+package dhtsim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexbakker/tox4go/dht"
+)
+
+func TestSimulation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping simulation in short mode")
+	}
+
+	sim, err := New(Options{Nodes: 100, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	bootstrap, err := sim.Bootstrap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bootstrap.Failed != 0 {
+		t.Fatalf("bad failed bootstrap count: expected: %d, actual: %d", 0, bootstrap.Failed)
+	}
+
+	lookups, err := sim.RunLookups(context.Background(), 100, dht.LookupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(bootstrap)
+	t.Log(lookups)
+
+	if lookups.Errors != 0 {
+		t.Fatalf("bad lookup error count: expected: %d, actual: %d", 0, lookups.Errors)
+	}
+	if rate := lookups.SuccessRate(); rate < 0.9 {
+		t.Fatalf("bad lookup success rate: %.2f", rate)
+	}
+	if lookups.MeanHops < 1 || lookups.MeanMessages < lookups.MeanQueries {
+		t.Fatalf("bad lookup metrics: %s", lookups)
+	}
+}
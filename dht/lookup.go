@@ -0,0 +1,196 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultLookupAlpha is the default amount of parallel queries during a
+	// lookup.
+	DefaultLookupAlpha = 3
+
+	// DefaultLookupK is the default amount of closest nodes a lookup converges
+	// on.
+	DefaultLookupK = 8
+
+	// DefaultQueryTimeout is the default amount of time to wait for a response
+	// to a single query.
+	DefaultQueryTimeout = 2 * time.Second
+)
+
+// ErrNoNodes is returned by a lookup if there are no nodes to query.
+var ErrNoNodes = errors.New("no nodes to query")
+
+type LookupOptions struct {
+	// Alpha is the amount of queries to run in parallel. If zero,
+	// DefaultLookupAlpha is used.
+	Alpha int
+	// K is the amount of closest nodes to converge on. If zero,
+	// DefaultLookupK is used.
+	K int
+	// QueryTimeout is the amount of time to wait for a response to a single
+	// query. If zero, DefaultQueryTimeout is used.
+	QueryTimeout time.Duration
+}
+
+// LookupStats contains statistics about a single lookup.
+type LookupStats struct {
+	// Queries is the amount of get nodes requests that were sent.
+	Queries int
+	// Failed is the amount of get nodes requests that failed or timed out.
+	Failed int
+	// Hops is the length of the chain of queries that led to the closest
+	// node that responded. A node from our own routing table is one hop
+	// away. It is zero if no nodes responded.
+	Hops int
+}
+
+type lookupState int
+
+const (
+	lookupStatePending lookupState = iota
+	lookupStateQuerying
+	lookupStateResponded
+	lookupStateFailed
+)
+
+type lookupCandidate struct {
+	node  *Node
+	state lookupState
+	// hops is the amount of queries it took to learn about the node
+	hops int
+}
+
+type lookupResult struct {
+	candidate *lookupCandidate
+	nodes     []*Node
+	err       error
+}
+
+// Lookup performs an iterative lookup for the nodes closest to the given target
+// public key. It starts with the closest nodes in the routing table and sends
+// get nodes requests to the closest nodes it hasn't queried yet, until the K
+// closest nodes it knows of have all responded or failed to respond. The nodes
+// that responded are returned, sorted by distance to the target.
+func (s *Server) Lookup(ctx context.Context, target *PublicKey, opts LookupOptions) ([]*Node, error) {
+	nodes, _, err := s.LookupWithStats(ctx, target, opts)
+	return nodes, err
+}
+
+// LookupWithStats is like Lookup, but also returns statistics about the
+// lookup. These are returned even if the lookup fails.
+func (s *Server) LookupWithStats(ctx context.Context, target *PublicKey, opts LookupOptions) ([]*Node, *LookupStats, error) {
+	alpha := opts.Alpha
+	if alpha <= 0 {
+		alpha = DefaultLookupAlpha
+	}
+
+	k := opts.K
+	if k <= 0 {
+		k = DefaultLookupK
+	}
+
+	queryTimeout := opts.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = DefaultQueryTimeout
+	}
+
+	var stats LookupStats
+	var candidates []*lookupCandidate
+	seen := make(map[PublicKey]struct{})
+	addCandidate := func(node *Node, hops int) {
+		if !node.reachable() {
+			return
+		}
+		if *node.PublicKey == *s.ident.PublicKey {
+			return
+		}
+		if _, ok := seen[*node.PublicKey]; ok {
+			return
+		}
+		seen[*node.PublicKey] = struct{}{}
+
+		i := sort.Search(len(candidates), func(i int) bool {
+			return target.CompareDistance(node.PublicKey, candidates[i].node.PublicKey) < 0
+		})
+		candidates = append(candidates, nil)
+		copy(candidates[i+1:], candidates[i:])
+		candidates[i] = &lookupCandidate{node: node, hops: hops}
+	}
+
+	for _, node := range s.table.Closest(target, k) {
+		addCandidate(node, 0)
+	}
+	if len(candidates) == 0 {
+		return nil, &stats, ErrNoNodes
+	}
+
+	// The results channel is buffered, so that queries that are still in
+	// flight when the lookup is canceled don't block forever
+	results := make(chan lookupResult, alpha)
+	var inFlight int
+	for {
+		var active int
+		for _, c := range candidates {
+			if inFlight >= alpha || active >= k {
+				break
+			}
+			if c.state == lookupStateFailed {
+				continue
+			}
+
+			active++
+			if c.state == lookupStatePending {
+				c.state = lookupStateQuerying
+				inFlight++
+				stats.Queries++
+				go func(c *lookupCandidate) {
+					qctx, cancel := context.WithTimeout(ctx, queryTimeout)
+					defer cancel()
+
+					nodes, err := s.client.GetNodes(qctx, c.node, target)
+					results <- lookupResult{candidate: c, nodes: nodes, err: err}
+				}(c)
+			}
+		}
+
+		if inFlight == 0 {
+			break
+		}
+
+		select {
+		case res := <-results:
+			inFlight--
+			if res.err != nil {
+				res.candidate.state = lookupStateFailed
+				stats.Failed++
+				continue
+			}
+
+			res.candidate.state = lookupStateResponded
+			for _, node := range res.nodes {
+				addCandidate(node, res.candidate.hops+1)
+			}
+		case <-ctx.Done():
+			return nil, &stats, ctx.Err()
+		}
+	}
+
+	var res []*Node
+	for _, c := range candidates {
+		if len(res) >= k {
+			break
+		}
+		if c.state == lookupStateResponded {
+			if len(res) == 0 {
+				stats.Hops = c.hops + 1
+			}
+			res = append(res, c.node)
+		}
+	}
+
+	return res, &stats, nil
+}
@@ -30,6 +30,18 @@ const (
 	NodeTypeTCPIP6 NodeType = 0x8A
 )
 
+var (
+	// ErrTruncated is returned when decoding data that ends before all fields
+	// could be read.
+	ErrTruncated = util.ErrTruncated
+	// ErrTrailingData is returned when decoding data that has data left after
+	// all fields were read.
+	ErrTrailingData = util.ErrTrailingData
+	// ErrMalformed is returned when decoding data with a field that has an
+	// invalid value.
+	ErrMalformed = util.ErrMalformed
+)
+
 type Packet interface {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
@@ -50,6 +62,13 @@ type Node struct {
 	PublicKey *PublicKey
 	IP        net.IP
 	Port      int
+
+	// TransportAddr is the address of the node on the transport, for nodes
+	// that aren't reached by IP address and port, such as nodes behind a
+	// relay or in-memory nodes. If set, it's used instead of the IP address
+	// and port. It's not part of the wire format, so nodes without an IP
+	// address are never sent to other nodes.
+	TransportAddr net.Addr
 }
 
 // GetNodesPacket represents the encrypted portion of the GetNodes request.
@@ -98,12 +117,11 @@ func (p *GetNodesPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	p.PublicKey = new(PublicKey)
-	_, err := reader.Read(p.PublicKey[:])
-	if err != nil {
+	if err := util.ReadFull(reader, p.PublicKey[:]); err != nil {
 		return err
 	}
 
-	if err := binary.Read(reader, binary.BigEndian, &p.PingID); err != nil {
+	if err := util.ReadBinary(reader, binary.BigEndian, &p.PingID); err != nil {
 		return err
 	}
 
@@ -146,26 +164,25 @@ func (p *EncryptedPacket) MarshalBinary() ([]byte, error) {
 func (p *EncryptedPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
-	err := binary.Read(reader, binary.BigEndian, &p.Type)
+	err := util.ReadBinary(reader, binary.BigEndian, &p.Type)
 	if err != nil {
 		return err
 	}
 
 	p.SenderPublicKey = new(PublicKey)
-	_, err = reader.Read(p.SenderPublicKey[:])
+	err = util.ReadFull(reader, p.SenderPublicKey[:])
 	if err != nil {
 		return err
 	}
 
 	p.Nonce = new([crypto.NonceSize]byte)
-	_, err = reader.Read(p.Nonce[:])
+	err = util.ReadFull(reader, p.Nonce[:])
 	if err != nil {
 		return err
 	}
 
 	p.Payload = make([]byte, reader.Len())
-	_, err = reader.Read(p.Payload)
-	return err
+	return util.ReadFull(reader, p.Payload)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
@@ -206,13 +223,13 @@ func (p *SendNodesPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	var count byte
-	err := binary.Read(reader, binary.BigEndian, &count)
+	err := util.ReadBinary(reader, binary.BigEndian, &count)
 	if err != nil {
 		return err
 	}
 
 	if count > 4 {
-		return errors.New("too many nodes, the max is 4")
+		return fmt.Errorf("%w: too many nodes, the max is 4", ErrMalformed)
 	}
 
 	p.Nodes = make([]*Node, int(count))
@@ -222,7 +239,7 @@ func (p *SendNodesPacket) UnmarshalBinary(data []byte) error {
 
 		p.Nodes[i] = &Node{}
 
-		err = binary.Read(reader, binary.BigEndian, &nodeType)
+		err = util.ReadBinary(reader, binary.BigEndian, &nodeType)
 		if err != nil {
 			return err
 		}
@@ -233,12 +250,12 @@ func (p *SendNodesPacket) UnmarshalBinary(data []byte) error {
 		case NodeTypeUDPIP6, NodeTypeTCPIP6:
 			ipSize = net.IPv6len
 		default:
-			return fmt.Errorf("bad address family: %d", nodeType)
+			return fmt.Errorf("%w: bad address family: %d", ErrMalformed, nodeType)
 		}
 
 		nodeBytes := make([]byte, 1+ipSize+2+crypto.PublicKeySize)
 		nodeBytes[0] = byte(nodeType)
-		_, err := reader.Read(nodeBytes[1:])
+		err = util.ReadFull(reader, nodeBytes[1:])
 		if err != nil {
 			return err
 		}
@@ -249,7 +266,7 @@ func (p *SendNodesPacket) UnmarshalBinary(data []byte) error {
 		}
 	}
 
-	if err := binary.Read(reader, binary.BigEndian, &p.PingID); err != nil {
+	if err := util.ReadBinary(reader, binary.BigEndian, &p.PingID); err != nil {
 		return err
 	}
 
@@ -283,14 +300,14 @@ func (p *PingResponsePacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	var pingType PacketType
-	err := binary.Read(reader, binary.BigEndian, &pingType)
+	err := util.ReadBinary(reader, binary.BigEndian, &pingType)
 	if err != nil {
 		return err
 	} else if pingType != PacketTypePingResponse {
-		return fmt.Errorf("incorrect ping type: %d! is this a replay attack?", pingType)
+		return fmt.Errorf("%w: incorrect ping type: %d! is this a replay attack?", ErrMalformed, pingType)
 	}
 
-	if err := binary.Read(reader, binary.BigEndian, &p.PingID); err != nil {
+	if err := util.ReadBinary(reader, binary.BigEndian, &p.PingID); err != nil {
 		return err
 	}
 
@@ -324,14 +341,14 @@ func (p *PingRequestPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	var pingType PacketType
-	err := binary.Read(reader, binary.BigEndian, &pingType)
+	err := util.ReadBinary(reader, binary.BigEndian, &pingType)
 	if err != nil {
 		return err
 	} else if pingType != PacketTypePingRequest {
-		return fmt.Errorf("incorrect ping type: %d! is this a replay attack?", pingType)
+		return fmt.Errorf("%w: incorrect ping type: %d! is this a replay attack?", ErrMalformed, pingType)
 	}
 
-	if err := binary.Read(reader, binary.BigEndian, &p.PingID); err != nil {
+	if err := util.ReadBinary(reader, binary.BigEndian, &p.PingID); err != nil {
 		return err
 	}
 
@@ -349,7 +366,7 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 	var nodeType NodeType
 	var ipSize int
 
-	err := binary.Read(reader, binary.BigEndian, &nodeType)
+	err := util.ReadBinary(reader, binary.BigEndian, &nodeType)
 	if err != nil {
 		return err
 	}
@@ -360,25 +377,25 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 	case NodeTypeUDPIP6, NodeTypeTCPIP6:
 		ipSize = net.IPv6len
 	default:
-		return fmt.Errorf("unknown address family: %d", nodeType)
+		return fmt.Errorf("%w: unknown address family: %d", ErrMalformed, nodeType)
 	}
 	n.Type = nodeType
 
 	n.IP = make([]byte, ipSize)
-	_, err = reader.Read(n.IP)
+	err = util.ReadFull(reader, n.IP)
 	if err != nil {
 		return err
 	}
 
 	var port uint16
-	err = binary.Read(reader, binary.BigEndian, &port)
+	err = util.ReadBinary(reader, binary.BigEndian, &port)
 	if err != nil {
 		return err
 	}
 	n.Port = int(port)
 
 	n.PublicKey = new(PublicKey)
-	if _, err = reader.Read(n.PublicKey[:]); err != nil {
+	if err = util.ReadFull(reader, n.PublicKey[:]); err != nil {
 		return err
 	}
 
@@ -387,6 +404,10 @@ func (n *Node) UnmarshalBinary(data []byte) error {
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
 func (n *Node) MarshalBinary() ([]byte, error) {
+	if n.IP == nil {
+		return nil, fmt.Errorf("node has no ip address: %s", n.Addr())
+	}
+
 	buf := new(bytes.Buffer)
 
 	err := binary.Write(buf, binary.BigEndian, n.Type)
@@ -411,7 +432,13 @@ func (n *Node) MarshalBinary() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Addr returns the address of the node on the transport. This is the transport
+// address if set, and the IP address and port of the node otherwise.
 func (n *Node) Addr() net.Addr {
+	if n.TransportAddr != nil {
+		return n.TransportAddr
+	}
+
 	switch n.Type {
 	case NodeTypeUDPIP4, NodeTypeUDPIP6:
 		return &net.UDPAddr{IP: n.IP, Port: n.Port}
@@ -422,6 +449,12 @@ func (n *Node) Addr() net.Addr {
 	}
 }
 
+// reachable reports whether DHT requests can be sent to the node. This is the
+// case for UDP nodes and nodes with a transport address.
+func (n *Node) reachable() bool {
+	return n.TransportAddr != nil || n.Type == NodeTypeUDPIP4 || n.Type == NodeTypeUDPIP6
+}
+
 // UnmarshalText implements the encoding.TextUnmarshaler interface.
 func (t *NodeType) UnmarshalText(data []byte) error {
 	s := string(data)
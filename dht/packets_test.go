@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPacketsTruncated(t *testing.T) {
+	node := &Node{
+		Type:      NodeTypeUDPIP4,
+		PublicKey: &PublicKey{1},
+		IP:        net.IPv4(127, 0, 0, 1).To4(),
+		Port:      33445,
+	}
+
+	packets := []Packet{
+		&GetNodesPacket{PublicKey: &PublicKey{2}, PingID: 1},
+		&SendNodesPacket{Nodes: []*Node{node}, PingID: 2},
+		&PingRequestPacket{PingID: 3},
+		&PingResponsePacket{PingID: 4},
+	}
+
+	for _, packet := range packets {
+		data, err := packet.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newPacket := func() Packet {
+			switch packet.ID() {
+			case PacketTypeGetNodes:
+				return &GetNodesPacket{}
+			case PacketTypeSendNodes:
+				return &SendNodesPacket{}
+			case PacketTypePingRequest:
+				return &PingRequestPacket{}
+			default:
+				return &PingResponsePacket{}
+			}
+		}
+
+		for i := 0; i < len(data); i++ {
+			if err = newPacket().UnmarshalBinary(data[:i]); !errors.Is(err, ErrTruncated) {
+				t.Fatalf("%s truncated to %d bytes: bad error: %v", packet.ID(), i, err)
+			}
+		}
+
+		if err = newPacket().UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrTrailingData) {
+			t.Fatalf("%s with trailing data: bad error: %v", packet.ID(), err)
+		}
+	}
+
+	nodeData, err := node.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeData[0] = 0xff
+	if err = new(Node).UnmarshalBinary(nodeData); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrMalformed, err)
+	}
+
+	enc := &EncryptedPacket{
+		Type:            PacketTypePingRequest,
+		SenderPublicKey: &PublicKey{3},
+		Nonce:           new([24]byte),
+		Payload:         []byte{4, 5, 6},
+	}
+	encData, err := enc.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(encData)-len(enc.Payload); i++ {
+		if err = new(EncryptedPacket).UnmarshalBinary(encData[:i]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("encrypted packet truncated to %d bytes: bad error: %v", i, err)
+		}
+	}
+}
@@ -5,21 +5,21 @@ import (
 	"time"
 
 	"github.com/alexbakker/tox4go/crypto"
-	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/dht/dhtkey"
 )
 
 // now is the function used to obtain the current time. It is overridden by tests.
 var now = time.Now
 
 type Ping struct {
-	publicKey *dht.PublicKey
+	publicKey *dhtkey.PublicKey
 	id        uint64
 	time      time.Time
 
 	e *list.Element
 }
 
-func New(publicKey *dht.PublicKey) (*Ping, error) {
+func New(publicKey *dhtkey.PublicKey) (*Ping, error) {
 	pingID, err := crypto.GeneratePingID()
 	if err != nil {
 		return nil, err
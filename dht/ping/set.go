@@ -6,7 +6,7 @@ import (
 	"maps"
 	"time"
 
-	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/dht/dhtkey"
 )
 
 const (
@@ -15,7 +15,7 @@ const (
 
 type pingKey struct {
 	ID        uint64
-	PublicKey dht.PublicKey
+	PublicKey dhtkey.PublicKey
 }
 
 type Set struct {
@@ -38,7 +38,7 @@ func (s *Set) Size() int {
 	return len(s.pings)
 }
 
-func (s *Set) Add(publicKey *dht.PublicKey) (*Ping, error) {
+func (s *Set) Add(publicKey *dhtkey.PublicKey) (*Ping, error) {
 	s.clearExpired()
 
 	p, err := New(publicKey)
@@ -60,7 +60,7 @@ func (s *Set) Add(publicKey *dht.PublicKey) (*Ping, error) {
 // is found, it is removed from the set and returned. If it is not found, an
 // error is returned. Expiry can also be the reason that the given ping ID is
 // not found, but this will not be reported as a separate error.
-func (s *Set) Pop(publicKey *dht.PublicKey, id uint64) (*Ping, error) {
+func (s *Set) Pop(publicKey *dhtkey.PublicKey, id uint64) (*Ping, error) {
 	s.clearExpired()
 
 	key := pingKey{PublicKey: *publicKey, ID: id}
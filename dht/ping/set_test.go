@@ -5,11 +5,11 @@ import (
 	"testing"
 	"time"
 
-	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/dht/dhtkey"
 )
 
-func generatePublicKey(t *testing.T) *dht.PublicKey {
-	var res dht.PublicKey
+func generatePublicKey(t *testing.T) *dhtkey.PublicKey {
+	var res dhtkey.PublicKey
 	if _, err := rand.Read(res[:]); err != nil {
 		t.Fatal(err)
 	}
@@ -17,7 +17,7 @@ func generatePublicKey(t *testing.T) *dht.PublicKey {
 	return &res
 }
 
-func addPing(t *testing.T, set *Set) (*dht.PublicKey, *Ping) {
+func addPing(t *testing.T, set *Set) (*dhtkey.PublicKey, *Ping) {
 	pk := generatePublicKey(t)
 	p, err := set.Add(pk)
 	if err != nil {
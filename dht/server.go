@@ -0,0 +1,184 @@
+package dht
+
+import (
+	"errors"
+	"math"
+	"net"
+
+	"github.com/alexbakker/tox4go/transport"
+)
+
+const (
+	// maxSendNodes is the maximum amount of nodes in a SendNodes packet.
+	maxSendNodes = 4
+)
+
+// Server is a DHT node that answers ping and get nodes requests from other
+// nodes in the DHT. Nodes that send us a valid request or that respond to one
+// of our requests are added to the routing table.
+//
+// The HandlePacket method of a Server is meant to be passed to a transport as
+// its packet handler:
+//
+//	srv := dht.NewServer(ident, dht.ServerOptions{})
+//	tr, err := transport.NewUDPTransport("udp", ":33445", srv.HandlePacket, transport.UDPTransportOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	srv.SetTransport(tr)
+type Server struct {
+	ident  *Identity
+	table  *RoutingTable
+	client *Client
+}
+
+type ServerOptions struct {
+	// Table is the routing table used by the server. If nil, a new routing
+	// table is created for the public key of the identity.
+	Table *RoutingTable
+	// Client contains the options for the client the server uses to send
+	// requests.
+	Client ClientOptions
+}
+
+// NewServer creates a new DHT server for the given identity.
+func NewServer(ident *Identity, opts ServerOptions) *Server {
+	table := opts.Table
+	if table == nil {
+		table = NewRoutingTable(ident.PublicKey, RoutingTableOptions{})
+	}
+
+	return &Server{
+		ident:  ident,
+		table:  table,
+		client: NewClient(ident, opts.Client),
+	}
+}
+
+// SetTransport sets the transport used to send packets. It must be called
+// before the transport starts listening for packets.
+func (s *Server) SetTransport(t transport.Transport) {
+	s.client.SetTransport(t)
+}
+
+// Identity returns the DHT identity of the server.
+func (s *Server) Identity() *Identity {
+	return s.ident
+}
+
+// Client returns the client the server uses to send requests. Responses to
+// these requests are delivered to the client by the server.
+func (s *Server) Client() *Client {
+	return s.client
+}
+
+// Table returns the routing table of the server.
+func (s *Server) Table() *RoutingTable {
+	return s.table
+}
+
+// RegisterHandlers registers the server as the handler for all DHT packet
+// types on the given packet multiplexer. Use this instead of passing
+// HandlePacket to the transport directly if the transport is shared with
+// other protocols.
+func (s *Server) RegisterHandlers(mux *transport.Mux) {
+	for _, packetType := range []PacketType{
+		PacketTypePingRequest,
+		PacketTypePingResponse,
+		PacketTypeGetNodes,
+		PacketTypeSendNodes,
+	} {
+		mux.Handle(byte(packetType), s.HandlePacket)
+	}
+}
+
+// HandlePacket implements transport.PacketHandler. Packets that are not valid
+// DHT packets addressed to us are dropped.
+func (s *Server) HandlePacket(data []byte, addr net.Addr) {
+	var encPacket EncryptedPacket
+	if err := encPacket.UnmarshalBinary(data); err != nil {
+		return
+	}
+
+	packet, err := s.ident.DecryptPacket(&encPacket)
+	if err != nil {
+		return
+	}
+
+	node, err := newNode(encPacket.SenderPublicKey, addr)
+	if err != nil {
+		return
+	}
+
+	switch p := packet.(type) {
+	case *PingRequestPacket:
+		s.table.Insert(node)
+		_ = s.SendPacket(&PingResponsePacket{PingID: p.PingID}, node)
+	case *GetNodesPacket:
+		s.table.Insert(node)
+		_ = s.SendPacket(&SendNodesPacket{
+			Nodes:  s.closestShareable(p.PublicKey),
+			PingID: p.PingID,
+		}, node)
+	default:
+		if s.client.handleResponse(node.PublicKey, packet) {
+			s.table.Insert(node)
+		}
+	}
+}
+
+// SendPacket encrypts the given packet for the given node and sends it.
+func (s *Server) SendPacket(packet Packet, node *Node) error {
+	return s.client.SendPacket(packet, node)
+}
+
+// closestShareable returns up to maxSendNodes nodes that are closest to the
+// given public key and that can be sent to other nodes.
+func (s *Server) closestShareable(publicKey *PublicKey) []*Node {
+	var nodes []*Node
+	for _, node := range s.table.Closest(publicKey, math.MaxInt) {
+		if node.IP == nil {
+			continue
+		}
+
+		nodes = append(nodes, node)
+		if len(nodes) == maxSendNodes {
+			break
+		}
+	}
+
+	return nodes
+}
+
+// newNode creates a new node with the given public key and address. A UDP
+// address results in a UDP node. Any other address is used as the transport
+// address of the node, so that the node is reachable, but not advertised to
+// other nodes. This includes the address of a TCP connection, the port of which
+// is usually ephemeral.
+func newNode(publicKey *PublicKey, addr net.Addr) (*Node, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		if addr == nil {
+			return nil, errors.New("nil address")
+		}
+		return &Node{PublicKey: publicKey, TransportAddr: addr}, nil
+	}
+
+	addrPort, err := transport.AddrPort(udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		Type:      NodeTypeUDPIP6,
+		PublicKey: publicKey,
+		IP:        addrPort.Addr().AsSlice(),
+		Port:      int(addrPort.Port()),
+	}
+
+	if addrPort.Addr().Is4() {
+		node.Type = NodeTypeUDPIP4
+	}
+
+	return node, nil
+}
@@ -0,0 +1,285 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexbakker/tox4go/transport"
+)
+
+func newTestServer(t *testing.T, tableOpts RoutingTableOptions) *Server {
+	ident, err := NewIdentity(IdentityOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer(ident, ServerOptions{
+		Table: NewRoutingTable(ident.PublicKey, tableOpts),
+	})
+	tr, err := transport.NewUDPTransport("udp4", "127.0.0.1:0", srv.HandlePacket, transport.UDPTransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetTransport(tr)
+
+	go tr.Listen(context.Background())
+	t.Cleanup(func() { tr.Close() })
+	return srv
+}
+
+func serverNode(t *testing.T, srv *Server) *Node {
+	node, err := newNode(srv.ident.PublicKey, srv.client.transport.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return node
+}
+
+func TestServerHandlePacket(t *testing.T) {
+	srv := newTestServer(t, RoutingTableOptions{})
+	client := newTestServer(t, RoutingTableOptions{}).Client()
+
+	if _, err := client.Ping(context.Background(), serverNode(t, srv)); err != nil {
+		t.Fatal(err)
+	}
+
+	target := generateNode(t).PublicKey
+	for i := 0; i < 2*maxSendNodes; i++ {
+		node := generateNode(t)
+		node.IP = node.IP.To4()
+		srv.Table().Insert(node)
+	}
+	// The closest node to the target can't be sent to other nodes
+	hidden := &Node{PublicKey: target, TransportAddr: memAddr("hidden")}
+	if !srv.Table().Insert(hidden) {
+		t.Fatal("node was not inserted")
+	}
+
+	nodes, err := client.GetNodes(context.Background(), serverNode(t, srv), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != maxSendNodes {
+		t.Fatalf("bad amount of nodes: expected: %d, actual: %d", maxSendNodes, len(nodes))
+	}
+	for i, node := range nodes {
+		if *node.PublicKey == *target {
+			t.Fatal("node without an ip address was sent")
+		}
+		if i > 0 && target.CompareDistance(nodes[i-1].PublicKey, node.PublicKey) > 0 {
+			t.Fatalf("nodes are not sorted by distance: %d", i)
+		}
+	}
+}
+
+// memAddr is the address of a memTransport, which isn't an IP address.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+// memTransport is an in-memory transport that delivers packets to the other
+// transports in its network.
+type memTransport struct {
+	addr     memAddr
+	handler  transport.PacketHandler
+	mu       *sync.Mutex
+	network  map[memAddr]*memTransport
+	stopChan chan struct{}
+}
+
+func newMemTransport(network map[memAddr]*memTransport, mu *sync.Mutex, addr memAddr, handler transport.PacketHandler) *memTransport {
+	t := &memTransport{addr: addr, handler: handler, mu: mu, network: network, stopChan: make(chan struct{})}
+
+	mu.Lock()
+	defer mu.Unlock()
+	network[addr] = t
+	return t
+}
+
+func (t *memTransport) SendPacket(data []byte, addr net.Addr) error {
+	to, ok := addr.(memAddr)
+	if !ok {
+		return fmt.Errorf("%w: %s", transport.ErrUnsupportedAddr, addr)
+	}
+
+	t.mu.Lock()
+	dst, ok := t.network[to]
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown address: %s", to)
+	}
+
+	data = append([]byte(nil), data...)
+	go dst.HandlePacket(data, t.addr)
+	return nil
+}
+
+func (t *memTransport) HandlePacket(data []byte, addr net.Addr) {
+	t.handler(data, addr)
+}
+
+func (t *memTransport) LocalAddr() net.Addr {
+	return t.addr
+}
+
+func (t *memTransport) Listen(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+	case <-t.stopChan:
+	}
+	return nil
+}
+
+func (t *memTransport) Close() error {
+	close(t.stopChan)
+	return nil
+}
+
+func TestServerNonIPTransport(t *testing.T) {
+	var mu sync.Mutex
+	network := make(map[memAddr]*memTransport)
+	servers := make([]*Server, 2)
+	for i := range servers {
+		ident, err := NewIdentity(IdentityOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		servers[i] = NewServer(ident, ServerOptions{})
+		servers[i].SetTransport(newMemTransport(network, &mu, memAddr(fmt.Sprintf("server%d", i)), servers[i].HandlePacket))
+	}
+
+	node := serverNode(t, servers[0])
+	if node.TransportAddr != memAddr("server0") {
+		t.Fatalf("bad transport address: expected: %s, actual: %s", memAddr("server0"), node.TransportAddr)
+	}
+
+	client := servers[1].Client()
+	if _, err := client.Ping(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	if servers[0].Table().Get(servers[1].ident.PublicKey) == nil {
+		t.Fatal("node with a non-ip address missing from routing table")
+	}
+
+	// Nodes without an IP address are not sent to other nodes
+	nodes, err := client.GetNodes(context.Background(), node, servers[1].ident.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 0 {
+		t.Fatalf("bad amount of nodes: expected: %d, actual: %d", 0, len(nodes))
+	}
+
+	// TCP peers are only reachable through their connection
+	tcpAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 33445}
+	node, err = newNode(servers[0].ident.PublicKey, tcpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.IP != nil || node.TransportAddr != tcpAddr {
+		t.Fatalf("bad node for tcp address: %+v", node)
+	}
+}
+
+func TestServerLookup(t *testing.T) {
+	const count = 16
+
+	// All servers only know about the hub, which knows about all servers. The
+	// lookup has to go through the hub to find the target.
+	hub := newTestServer(t, RoutingTableOptions{BucketSize: count})
+	servers := make([]*Server, count)
+	for i := range servers {
+		servers[i] = newTestServer(t, RoutingTableOptions{})
+		servers[i].Table().Insert(serverNode(t, hub))
+		hub.Table().Insert(serverNode(t, servers[i]))
+	}
+
+	target := servers[0].ident.PublicKey
+	nodes, err := servers[count-1].Lookup(context.Background(), target, LookupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) == 0 {
+		t.Fatal("lookup returned no nodes")
+	}
+	if *nodes[0].PublicKey != *target {
+		t.Fatalf("bad closest node: expected: %s, actual: %s", target, nodes[0].PublicKey)
+	}
+}
+
+func TestServerBootstrap(t *testing.T) {
+	const count = 16
+
+	hub := newTestServer(t, RoutingTableOptions{BucketSize: count})
+	servers := make([]*Server, count)
+	for i := range servers {
+		servers[i] = newTestServer(t, RoutingTableOptions{})
+		hub.Table().Insert(serverNode(t, servers[i]))
+	}
+
+	srv := newTestServer(t, RoutingTableOptions{})
+	err := srv.Bootstrap(context.Background(), []*Node{serverNode(t, hub)}, BootstrapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.Table().Get(hub.ident.PublicKey) == nil {
+		t.Fatal("bootstrap node missing from routing table")
+	}
+	if srv.Table().Len() < 2 {
+		t.Fatalf("bad table size: expected at least: %d, actual: %d", 2, srv.Table().Len())
+	}
+
+	unreachable := serverNode(t, newTestServer(t, RoutingTableOptions{}))
+	unreachable.PublicKey = new(PublicKey)
+	err = newTestServer(t, RoutingTableOptions{}).Bootstrap(context.Background(), []*Node{unreachable}, BootstrapOptions{
+		Lookup: LookupOptions{QueryTimeout: 100 * time.Millisecond},
+	})
+	if !errors.Is(err, ErrBootstrapFailed) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrBootstrapFailed, err)
+	}
+
+	// Nodes that are already in the routing table don't count
+	err = srv.Bootstrap(context.Background(), []*Node{unreachable}, BootstrapOptions{
+		Lookup: LookupOptions{QueryTimeout: 100 * time.Millisecond},
+	})
+	if !errors.Is(err, ErrBootstrapFailed) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrBootstrapFailed, err)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	srv := newTestServer(t, RoutingTableOptions{})
+	client := newTestServer(t, RoutingTableOptions{}).Client()
+
+	if _, err := client.Ping(context.Background(), serverNode(t, srv)); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := client.GetNodes(context.Background(), serverNode(t, srv), srv.ident.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The server learned about the client through the ping request
+	if len(nodes) != 1 {
+		t.Fatalf("bad amount of nodes: expected: %d, actual: %d", 1, len(nodes))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	unreachable := serverNode(t, srv)
+	unreachable.PublicKey = new(PublicKey)
+	if _, err := client.Ping(ctx, unreachable); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("bad error: expected: %v, actual: %v", context.DeadlineExceeded, err)
+	}
+}
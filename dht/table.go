@@ -0,0 +1,262 @@
+package dht
+
+import (
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultBucketSize is the default maximum amount of nodes in a k-bucket.
+	DefaultBucketSize = 8
+
+	// DefaultCloseListSize is the default maximum amount of nodes in the close
+	// list.
+	DefaultCloseListSize = 32
+
+	bucketCount = PublicKeySize * 8
+)
+
+// RoutingTable keeps track of the nodes we know about in the DHT. Nodes are
+// sorted into k-buckets by the XOR distance between their public key and ours.
+// The nodes closest to our own public key are also kept in a separate close
+// list, so that they aren't lost if their bucket is full. It is safe for
+// concurrent use.
+type RoutingTable struct {
+	publicKey     *PublicKey
+	bucketSize    int
+	closeListSize int
+
+	mu        sync.RWMutex
+	buckets   [bucketCount][]*Node
+	closeList []*Node
+}
+
+type RoutingTableOptions struct {
+	// BucketSize is the maximum amount of nodes in a k-bucket. If zero,
+	// DefaultBucketSize is used.
+	BucketSize int
+	// CloseListSize is the maximum amount of nodes in the close list. If zero,
+	// DefaultCloseListSize is used.
+	CloseListSize int
+}
+
+// NewRoutingTable creates a new routing table for the given public key. This
+// is usually the public key of our own DHT identity.
+func NewRoutingTable(publicKey *PublicKey, opts RoutingTableOptions) *RoutingTable {
+	bucketSize := opts.BucketSize
+	if bucketSize <= 0 {
+		bucketSize = DefaultBucketSize
+	}
+
+	closeListSize := opts.CloseListSize
+	if closeListSize <= 0 {
+		closeListSize = DefaultCloseListSize
+	}
+
+	return &RoutingTable{
+		publicKey:     publicKey,
+		bucketSize:    bucketSize,
+		closeListSize: closeListSize,
+	}
+}
+
+// PublicKey returns the public key that the routing table is centered around.
+func (t *RoutingTable) PublicKey() *PublicKey {
+	return t.publicKey
+}
+
+// Insert adds the given node to the routing table. If a node with the same
+// public key is already present, it is replaced with the given node and moved
+// to the back of its bucket. Insert returns whether the node was added to
+// either its bucket or the close list. Nodes are not added to a bucket that is
+// full. Evict stale nodes first to make room for new ones.
+func (t *RoutingTable) Insert(node *Node) bool {
+	i := t.bucketIndex(node.PublicKey)
+	if i < 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	inBucket := t.insertBucket(i, node)
+	inCloseList := t.insertCloseList(node)
+	return inBucket || inCloseList
+}
+
+// Evict removes the node with the given public key from the routing table.
+// It returns whether the node was found.
+func (t *RoutingTable) Evict(publicKey *PublicKey) bool {
+	i := t.bucketIndex(publicKey)
+	if i < 0 {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var found bool
+	if j := indexOfNode(t.buckets[i], publicKey); j != -1 {
+		t.buckets[i] = removeNode(t.buckets[i], j)
+		found = true
+	}
+	if j := indexOfNode(t.closeList, publicKey); j != -1 {
+		t.closeList = removeNode(t.closeList, j)
+		found = true
+	}
+
+	return found
+}
+
+// Get returns the node with the given public key, or nil if it is not in the
+// routing table.
+func (t *RoutingTable) Get(publicKey *PublicKey) *Node {
+	i := t.bucketIndex(publicKey)
+	if i < 0 {
+		return nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if j := indexOfNode(t.buckets[i], publicKey); j != -1 {
+		return t.buckets[i][j]
+	}
+	if j := indexOfNode(t.closeList, publicKey); j != -1 {
+		return t.closeList[j]
+	}
+
+	return nil
+}
+
+// Closest returns up to n nodes that are closest to the given public key,
+// sorted by distance.
+func (t *RoutingTable) Closest(publicKey *PublicKey, n int) []*Node {
+	nodes := t.Nodes()
+	sort.Slice(nodes, func(i, j int) bool {
+		return publicKey.CompareDistance(nodes[i].PublicKey, nodes[j].PublicKey) < 0
+	})
+
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// CloseList returns a copy of the close list, sorted by distance to the public
+// key of the routing table.
+func (t *RoutingTable) CloseList() []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return append([]*Node(nil), t.closeList...)
+}
+
+// Bucket returns a copy of the k-bucket at the given index. The index of a
+// bucket is the length of the common prefix between the public key of the
+// routing table and the public keys of the nodes in the bucket.
+func (t *RoutingTable) Bucket(i int) []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return append([]*Node(nil), t.buckets[i]...)
+}
+
+// Nodes returns a snapshot of all nodes in the routing table.
+func (t *RoutingTable) Nodes() []*Node {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var res []*Node
+	for _, bucket := range t.buckets {
+		res = append(res, bucket...)
+	}
+
+	for _, node := range t.closeList {
+		i := t.bucketIndex(node.PublicKey)
+		if indexOfNode(t.buckets[i], node.PublicKey) == -1 {
+			res = append(res, node)
+		}
+	}
+
+	return res
+}
+
+// Each calls fn for every node in the routing table, until fn returns false.
+// It operates on a snapshot, so fn may safely modify the routing table.
+func (t *RoutingTable) Each(fn func(node *Node) bool) {
+	for _, node := range t.Nodes() {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// Len returns the amount of nodes in the routing table.
+func (t *RoutingTable) Len() int {
+	return len(t.Nodes())
+}
+
+func (t *RoutingTable) insertBucket(i int, node *Node) bool {
+	bucket := t.buckets[i]
+	if j := indexOfNode(bucket, node.PublicKey); j != -1 {
+		bucket = removeNode(bucket, j)
+	} else if len(bucket) >= t.bucketSize {
+		return false
+	}
+
+	t.buckets[i] = append(bucket, node)
+	return true
+}
+
+func (t *RoutingTable) insertCloseList(node *Node) bool {
+	if j := indexOfNode(t.closeList, node.PublicKey); j != -1 {
+		t.closeList[j] = node
+		return true
+	}
+
+	i := sort.Search(len(t.closeList), func(i int) bool {
+		return t.publicKey.CompareDistance(node.PublicKey, t.closeList[i].PublicKey) < 0
+	})
+	if i >= t.closeListSize {
+		return false
+	}
+
+	t.closeList = append(t.closeList, nil)
+	copy(t.closeList[i+1:], t.closeList[i:])
+	t.closeList[i] = node
+
+	if len(t.closeList) > t.closeListSize {
+		t.closeList = t.closeList[:t.closeListSize]
+	}
+	return true
+}
+
+// bucketIndex returns the index of the bucket for the given public key, or -1
+// if the given public key is our own.
+func (t *RoutingTable) bucketIndex(publicKey *PublicKey) int {
+	for i := 0; i < PublicKeySize; i++ {
+		if dist := t.publicKey[i] ^ publicKey[i]; dist != 0 {
+			return i*8 + bits.LeadingZeros8(dist)
+		}
+	}
+
+	return -1
+}
+
+func indexOfNode(nodes []*Node, publicKey *PublicKey) int {
+	for i, node := range nodes {
+		if *node.PublicKey == *publicKey {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func removeNode(nodes []*Node, i int) []*Node {
+	copy(nodes[i:], nodes[i+1:])
+	nodes[len(nodes)-1] = nil
+	return nodes[:len(nodes)-1]
+}
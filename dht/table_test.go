@@ -0,0 +1,138 @@
+package dht
+
+import (
+	"crypto/rand"
+	"net"
+	"testing"
+)
+
+func generateNode(t *testing.T) *Node {
+	var pk PublicKey
+	if _, err := rand.Read(pk[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Node{
+		Type:      NodeTypeUDPIP4,
+		PublicKey: &pk,
+		IP:        net.IPv4(127, 0, 0, 1),
+		Port:      33445,
+	}
+}
+
+// generateNodeInBucket generates a node that shares a common prefix of exactly
+// i bits with the given public key.
+func generateNodeInBucket(t *testing.T, publicKey *PublicKey, i int) *Node {
+	node := generateNode(t)
+	for j := 0; j < i; j++ {
+		mask := byte(0x80) >> (j % 8)
+		node.PublicKey[j/8] = node.PublicKey[j/8]&^mask | publicKey[j/8]&mask
+	}
+
+	mask := byte(0x80) >> (i % 8)
+	node.PublicKey[i/8] = node.PublicKey[i/8]&^mask | ^publicKey[i/8]&mask
+	return node
+}
+
+func TestRoutingTableInsert(t *testing.T) {
+	self := generateNode(t).PublicKey
+	table := NewRoutingTable(self, RoutingTableOptions{CloseListSize: 4})
+
+	if table.Insert(&Node{PublicKey: self}) {
+		t.Fatal("inserted our own public key")
+	}
+
+	for i := 0; i < DefaultBucketSize; i++ {
+		node := generateNodeInBucket(t, self, 0)
+		if !table.Insert(node) {
+			t.Fatalf("unable to insert node %d", i)
+		}
+	}
+
+	// The node may still end up in the close list, but not in its bucket
+	extra := generateNodeInBucket(t, self, 0)
+	table.Insert(extra)
+
+	bucket := table.Bucket(0)
+	if len(bucket) != DefaultBucketSize {
+		t.Fatalf("bad bucket size: expected: %d, actual: %d", DefaultBucketSize, len(bucket))
+	}
+	if indexOfNode(bucket, extra.PublicKey) != -1 {
+		t.Fatal("inserted node into full bucket")
+	}
+
+	// Reinserting a node moves it to the back of its bucket
+	if !table.Insert(bucket[0]) {
+		t.Fatal("unable to reinsert node")
+	}
+	if newBucket := table.Bucket(0); newBucket[len(newBucket)-1] != bucket[0] {
+		t.Fatal("reinserted node not at the back of the bucket")
+	}
+
+	if len(table.CloseList()) != 4 {
+		t.Fatalf("bad close list size: expected: %d, actual: %d", 4, len(table.CloseList()))
+	}
+}
+
+func TestRoutingTableCloseList(t *testing.T) {
+	self := generateNode(t).PublicKey
+	table := NewRoutingTable(self, RoutingTableOptions{BucketSize: 1, CloseListSize: 2})
+
+	far := generateNodeInBucket(t, self, 10)
+	close1 := generateNodeInBucket(t, self, 20)
+	close2 := generateNodeInBucket(t, self, 20)
+	for _, node := range []*Node{far, close1, close2} {
+		table.Insert(node)
+	}
+
+	// close2 doesn't fit in its bucket, but it should still be in the close list
+	closeList := table.CloseList()
+	if len(closeList) != 2 {
+		t.Fatalf("bad close list size: expected: %d, actual: %d", 2, len(closeList))
+	}
+	if table.Get(close2.PublicKey) == nil {
+		t.Fatal("node missing from close list")
+	}
+	if table.Len() != 3 {
+		t.Fatalf("bad table size: expected: %d, actual: %d", 3, table.Len())
+	}
+
+	if !table.Evict(close2.PublicKey) {
+		t.Fatal("unable to evict node")
+	}
+	if table.Get(close2.PublicKey) != nil {
+		t.Fatal("evicted node still in table")
+	}
+	if table.Evict(close2.PublicKey) {
+		t.Fatal("evicted node a second time")
+	}
+}
+
+func TestRoutingTableClosest(t *testing.T) {
+	self := generateNode(t).PublicKey
+	table := NewRoutingTable(self, RoutingTableOptions{})
+
+	for i := 0; i < 100; i++ {
+		table.Insert(generateNode(t))
+	}
+
+	target := generateNode(t).PublicKey
+	closest := table.Closest(target, 4)
+	if len(closest) != 4 {
+		t.Fatalf("bad amount of nodes: expected: %d, actual: %d", 4, len(closest))
+	}
+
+	for i := 1; i < len(closest); i++ {
+		if target.Closest(closest[i-1].PublicKey, closest[i].PublicKey) != closest[i-1].PublicKey {
+			t.Fatal("nodes not sorted by distance")
+		}
+	}
+
+	table.Each(func(node *Node) bool {
+		if target.Closest(node.PublicKey, closest[len(closest)-1].PublicKey) == node.PublicKey &&
+			indexOfNode(closest, node.PublicKey) == -1 {
+			t.Fatal("closer node not returned")
+		}
+		return true
+	})
+}
@@ -13,7 +13,7 @@
           src = ./.;
 
           subPackages = [ "cmd/state-tool" ];
-          vendorHash = "sha256-bd5nJCbg+baRVf5VU6ErLR9x1doTO5zBOq8UIlHWa1U=";
+          vendorHash = "sha256-xBIjs4obyLuyDF8Kzf6ejufLjzaJCnhsZCYdY8ReFfU=";
 
           postInstall = ''
             mv $out/bin/state-tool $out/bin/${name}
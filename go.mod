@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/hashicorp/golang-lru/v2 v2.0.7
 	golang.org/x/crypto v0.18.0
+	golang.org/x/net v0.20.0
 )
 
 require golang.org/x/sys v0.16.0 // indirect
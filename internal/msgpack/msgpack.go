@@ -0,0 +1,291 @@
+// Package msgpack implements the subset of MessagePack that is used by the Tox
+// state format: arrays, booleans, unsigned integers, binary data and nil.
+// Values are always written in their smallest possible representation, which
+// is what c-toxcore does as well.
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/alexbakker/tox4go/internal/util"
+)
+
+const (
+	typeNil     = 0xc0
+	typeFalse   = 0xc2
+	typeTrue    = 0xc3
+	typeBin8    = 0xc4
+	typeBin16   = 0xc5
+	typeBin32   = 0xc6
+	typeUint8   = 0xcc
+	typeUint16  = 0xcd
+	typeUint32  = 0xce
+	typeUint64  = 0xcf
+	typeInt8    = 0xd0
+	typeInt16   = 0xd1
+	typeInt32   = 0xd2
+	typeInt64   = 0xd3
+	typeArray16 = 0xdc
+	typeArray32 = 0xdd
+
+	maxPositiveFixint = 0x7f
+	fixarrayMask      = 0x90
+	maxFixarrayLen    = 0x0f
+)
+
+// ErrUnexpectedType is returned when the next value is not of the type that
+// the caller tried to read. It wraps util.ErrMalformed.
+var ErrUnexpectedType = fmt.Errorf("%w: msgpack: unexpected type", util.ErrMalformed)
+
+var errNegative = fmt.Errorf("%w: msgpack: negative integer", util.ErrMalformed)
+
+// Writer appends MessagePack-encoded values to a buffer.
+type Writer struct {
+	buf []byte
+}
+
+// Bytes returns the values written so far.
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// WriteArrayHeader writes the header of an array with the given length. The
+// elements of the array must be written after it.
+func (w *Writer) WriteArrayHeader(n int) {
+	switch {
+	case n <= maxFixarrayLen:
+		w.buf = append(w.buf, byte(fixarrayMask|n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, typeArray16)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, typeArray32)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+// WriteNil writes a nil value.
+func (w *Writer) WriteNil() {
+	w.buf = append(w.buf, typeNil)
+}
+
+// WriteBool writes a boolean.
+func (w *Writer) WriteBool(v bool) {
+	if v {
+		w.buf = append(w.buf, typeTrue)
+	} else {
+		w.buf = append(w.buf, typeFalse)
+	}
+}
+
+// WriteUint writes an unsigned integer.
+func (w *Writer) WriteUint(v uint64) {
+	switch {
+	case v <= maxPositiveFixint:
+		w.buf = append(w.buf, byte(v))
+	case v <= math.MaxUint8:
+		w.buf = append(w.buf, typeUint8, byte(v))
+	case v <= math.MaxUint16:
+		w.buf = append(w.buf, typeUint16)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(v))
+	case v <= math.MaxUint32:
+		w.buf = append(w.buf, typeUint32)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v))
+	default:
+		w.buf = append(w.buf, typeUint64)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, v)
+	}
+}
+
+// WriteBin writes binary data.
+func (w *Writer) WriteBin(data []byte) {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, typeBin8, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, typeBin16)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, typeBin32)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, data...)
+}
+
+// Reader reads MessagePack-encoded values from a buffer.
+type Reader struct {
+	data []byte
+	off  int
+}
+
+// NewReader creates a new reader for the given data.
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+// Len returns the amount of bytes that have not been read yet.
+func (r *Reader) Len() int {
+	return len(r.data) - r.off
+}
+
+// ReadArrayHeader reads the header of an array and returns its length.
+func (r *Reader) ReadArrayHeader() (int, error) {
+	t, err := r.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case t&0xf0 == fixarrayMask:
+		r.off++
+		return int(t & maxFixarrayLen), nil
+	case t == typeArray16:
+		n, err := r.readN(1, 2)
+		return int(n), err
+	case t == typeArray32:
+		n, err := r.readN(1, 4)
+		return int(n), err
+	default:
+		return 0, fmt.Errorf("%w: 0x%x is not an array", ErrUnexpectedType, t)
+	}
+}
+
+// ReadArray reads the header of an array and checks that it has the given
+// length.
+func (r *Reader) ReadArray(n int) error {
+	l, err := r.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	if l != n {
+		return fmt.Errorf("%w: msgpack: unexpected array length: %d (should be %d)", util.ErrMalformed, l, n)
+	}
+
+	return nil
+}
+
+// ReadBool reads a boolean.
+func (r *Reader) ReadBool() (bool, error) {
+	t, err := r.peek()
+	if err != nil {
+		return false, err
+	}
+
+	switch t {
+	case typeFalse, typeTrue:
+		r.off++
+		return t == typeTrue, nil
+	default:
+		return false, fmt.Errorf("%w: 0x%x is not a boolean", ErrUnexpectedType, t)
+	}
+}
+
+// ReadUint reads an unsigned integer that fits in the given amount of bits.
+// Signed integers are accepted as well, as long as they are not negative.
+func (r *Reader) ReadUint(bits int) (uint64, error) {
+	t, err := r.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	var v uint64
+	switch t {
+	case typeUint8, typeInt8:
+		v, err = r.readN(1, 1)
+		if t == typeInt8 && v > math.MaxInt8 {
+			return 0, errNegative
+		}
+	case typeUint16, typeInt16:
+		v, err = r.readN(1, 2)
+		if t == typeInt16 && v > math.MaxInt16 {
+			return 0, errNegative
+		}
+	case typeUint32, typeInt32:
+		v, err = r.readN(1, 4)
+		if t == typeInt32 && v > math.MaxInt32 {
+			return 0, errNegative
+		}
+	case typeUint64, typeInt64:
+		v, err = r.readN(1, 8)
+		if t == typeInt64 && v > math.MaxInt64 {
+			return 0, errNegative
+		}
+	default:
+		if t > maxPositiveFixint {
+			return 0, fmt.Errorf("%w: 0x%x is not an unsigned integer", ErrUnexpectedType, t)
+		}
+		r.off++
+		v = uint64(t)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if bits < 64 && v>>bits != 0 {
+		return 0, fmt.Errorf("%w: msgpack: integer overflows %d bits: %d", util.ErrMalformed, bits, v)
+	}
+
+	return v, nil
+}
+
+// ReadBin reads binary data. A nil value is read as nil data. The returned
+// slice refers to the buffer of the reader.
+func (r *Reader) ReadBin() ([]byte, error) {
+	t, err := r.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	var n uint64
+	switch t {
+	case typeNil:
+		r.off++
+		return nil, nil
+	case typeBin8:
+		n, err = r.readN(1, 1)
+	case typeBin16:
+		n, err = r.readN(1, 2)
+	case typeBin32:
+		n, err = r.readN(1, 4)
+	default:
+		return nil, fmt.Errorf("%w: 0x%x is not binary data", ErrUnexpectedType, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(r.Len()) < n {
+		return nil, util.ErrTruncated
+	}
+
+	data := r.data[r.off : r.off+int(n)]
+	r.off += int(n)
+	return data, nil
+}
+
+func (r *Reader) peek() (byte, error) {
+	if r.Len() < 1 {
+		return 0, util.ErrTruncated
+	}
+
+	return r.data[r.off], nil
+}
+
+// readN skips the given amount of bytes and then reads a big-endian unsigned
+// integer of the given size.
+func (r *Reader) readN(skip int, size int) (uint64, error) {
+	if r.Len() < skip+size {
+		return 0, util.ErrTruncated
+	}
+
+	var v uint64
+	for _, b := range r.data[r.off+skip : r.off+skip+size] {
+		v = v<<8 | uint64(b)
+	}
+
+	r.off += skip + size
+	return v, nil
+}
@@ -2,12 +2,68 @@ package util
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+)
+
+var (
+	// ErrTruncated is returned by decoders if the data ends before all fields
+	// could be read.
+	ErrTruncated = errors.New("truncated data")
+
+	// ErrTrailingData is returned by decoders if there is data left after all
+	// fields were read.
+	ErrTrailingData = errors.New("unexpected trailing data")
+
+	// ErrMalformed is returned by decoders if a field has an invalid value.
+	ErrMalformed = errors.New("malformed data")
 )
 
 func AssertReaderEOF(reader *bytes.Reader) error {
 	if reader.Len() != 0 {
-		return fmt.Errorf("bad packet: %d unexpected extra bytes", reader.Len())
+		return fmt.Errorf("%w: %d unexpected extra bytes", ErrTrailingData, reader.Len())
+	}
+	return nil
+}
+
+// ReadFull reads exactly len(buf) bytes from the given reader. If the reader
+// has less bytes left than that, ErrTruncated is returned.
+func ReadFull(reader io.Reader, buf []byte) error {
+	n, err := io.ReadFull(reader, buf)
+	if err != nil {
+		return truncatedError(err, len(buf), n)
+	}
+	return nil
+}
+
+// ReadBinary is like binary.Read, except that it returns ErrTruncated if the
+// reader has less bytes left than the size of data.
+func ReadBinary(reader io.Reader, order binary.ByteOrder, data any) error {
+	if err := binary.Read(reader, order, data); err != nil {
+		return truncatedError(err, binary.Size(data), -1)
 	}
 	return nil
 }
+
+// ReadByte reads a single byte from the given reader. If the reader has no
+// bytes left, ErrTruncated is returned.
+func ReadByte(reader io.ByteReader) (byte, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, truncatedError(err, 1, 0)
+	}
+	return b, nil
+}
+
+func truncatedError(err error, expected int, actual int) error {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	if actual < 0 {
+		return fmt.Errorf("%w: expected %d more bytes", ErrTruncated, expected)
+	}
+	return fmt.Errorf("%w: expected %d more bytes, got %d", ErrTruncated, expected, actual)
+}
@@ -4,6 +4,16 @@ import (
 	"bytes"
 
 	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/internal/util"
+)
+
+var (
+	// ErrTruncated is returned when decoding data that ends before all fields
+	// could be read.
+	ErrTruncated = util.ErrTruncated
+	// ErrTrailingData is returned when decoding data that has data left after
+	// all fields were read.
+	ErrTrailingData = util.ErrTrailingData
 )
 
 type Packet struct {
@@ -49,14 +59,18 @@ func (p *HandshakePayload) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	p.PublicKey = new([crypto.PublicKeySize]byte)
-	_, err := reader.Read(p.PublicKey[:])
+	err := util.ReadFull(reader, p.PublicKey[:])
 	if err != nil {
 		return err
 	}
 
 	p.BaseNonce = new([crypto.NonceSize]byte)
-	_, err = reader.Read(p.BaseNonce[:])
-	return err
+	err = util.ReadFull(reader, p.BaseNonce[:])
+	if err != nil {
+		return err
+	}
+
+	return util.AssertReaderEOF(reader)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
@@ -86,20 +100,19 @@ func (p *HandshakeRequestPacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	p.PublicKey = new([crypto.PublicKeySize]byte)
-	_, err := reader.Read(p.PublicKey[:])
+	err := util.ReadFull(reader, p.PublicKey[:])
 	if err != nil {
 		return err
 	}
 
 	p.Nonce = new([crypto.NonceSize]byte)
-	_, err = reader.Read(p.Nonce[:])
+	err = util.ReadFull(reader, p.Nonce[:])
 	if err != nil {
 		return err
 	}
 
 	p.Payload = make([]byte, reader.Len())
-	_, err = reader.Read(p.Payload)
-	return err
+	return util.ReadFull(reader, p.Payload)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
@@ -124,12 +137,11 @@ func (p *HandshakeResponsePacket) UnmarshalBinary(data []byte) error {
 	reader := bytes.NewReader(data)
 
 	p.Nonce = new([crypto.NonceSize]byte)
-	_, err := reader.Read(p.Nonce[:])
+	err := util.ReadFull(reader, p.Nonce[:])
 	if err != nil {
 		return err
 	}
 
 	p.Payload = make([]byte, reader.Len())
-	_, err = reader.Read(p.Payload)
-	return err
+	return util.ReadFull(reader, p.Payload)
 }
@@ -0,0 +1,262 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/internal/util"
+)
+
+// ConferenceType represents the type of a conference.
+type ConferenceType byte
+
+const (
+	// ConferenceTypeText indicates a text-only conference.
+	ConferenceTypeText ConferenceType = iota
+	// ConferenceTypeAV indicates an audio/video conference.
+	ConferenceTypeAV
+)
+
+const (
+	// ConferenceIDSize is the size of a conference ID in bytes.
+	ConferenceIDSize = 32
+
+	maxConferenceTitleSize = 128
+	maxConferenceNickSize  = 128
+)
+
+// Conference represents the structure of conferences (legacy group chats)
+// that can be found inside a Tox state file.
+type Conference struct {
+	Type               ConferenceType
+	ID                 *[ConferenceIDSize]byte
+	MessageNumber      uint32
+	LossyMessageNumber uint16
+	// PeerNumber is our own peer number in the conference.
+	PeerNumber uint16
+	Title      string
+	// Peers contains the peers of the conference. The state file doesn't
+	// distinguish between peers that were online and frozen peers that were
+	// offline when it was saved. Tox clients treat all of them as frozen peers
+	// until they rejoin the conference.
+	Peers []*ConferencePeer
+}
+
+// ConferencePeer represents the structure of conference peers that can be
+// found inside a Tox state file.
+type ConferencePeer struct {
+	PublicKey     *[crypto.PublicKeySize]byte
+	TempPublicKey *[crypto.PublicKeySize]byte
+	PeerNumber    uint16
+	// LastActive is the Unix timestamp of the last time the peer was active.
+	LastActive uint64
+	Nick       string
+}
+
+type sectionConferences struct {
+	Conferences []*Conference
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (s *sectionConferences) UnmarshalBinary(data []byte) (err error) {
+	reader := bytes.NewReader(data)
+	fields := newFieldTracker(reader)
+	defer func() { err = fields.wrap(err) }()
+
+	for reader.Len() > 0 {
+		conf := new(Conference)
+		confPrefix := fmt.Sprintf("conference %d: ", len(s.Conferences))
+		fields.prefix = confPrefix
+
+		fields.begin("type")
+		confType, err := util.ReadByte(reader)
+		if err != nil {
+			return err
+		}
+		conf.Type = ConferenceType(confType)
+
+		fields.begin("id")
+		conf.ID = new([ConferenceIDSize]byte)
+		err = util.ReadFull(reader, conf.ID[:])
+		if err != nil {
+			return err
+		}
+
+		fields.begin("message number")
+		err = util.ReadBinary(reader, binary.LittleEndian, &conf.MessageNumber)
+		if err != nil {
+			return err
+		}
+
+		fields.begin("lossy message number")
+		err = util.ReadBinary(reader, binary.LittleEndian, &conf.LossyMessageNumber)
+		if err != nil {
+			return err
+		}
+
+		fields.begin("peer number")
+		err = util.ReadBinary(reader, binary.LittleEndian, &conf.PeerNumber)
+		if err != nil {
+			return err
+		}
+
+		fields.begin("peer count")
+		var peerCount uint32
+		err = util.ReadBinary(reader, binary.LittleEndian, &peerCount)
+		if err != nil {
+			return err
+		}
+
+		fields.begin("title")
+		conf.Title, err = readStringWithLength(reader, maxConferenceTitleSize)
+		if err != nil {
+			return err
+		}
+
+		for i := uint32(0); i < peerCount; i++ {
+			peer := new(ConferencePeer)
+			fields.prefix = confPrefix + fmt.Sprintf("peer %d: ", i)
+
+			fields.begin("public key")
+			peer.PublicKey = new([crypto.PublicKeySize]byte)
+			err = util.ReadFull(reader, peer.PublicKey[:])
+			if err != nil {
+				return err
+			}
+
+			fields.begin("temporary public key")
+			peer.TempPublicKey = new([crypto.PublicKeySize]byte)
+			err = util.ReadFull(reader, peer.TempPublicKey[:])
+			if err != nil {
+				return err
+			}
+
+			fields.begin("peer number")
+			err = util.ReadBinary(reader, binary.LittleEndian, &peer.PeerNumber)
+			if err != nil {
+				return err
+			}
+
+			fields.begin("last active")
+			err = util.ReadBinary(reader, binary.LittleEndian, &peer.LastActive)
+			if err != nil {
+				return err
+			}
+
+			fields.begin("nick")
+			peer.Nick, err = readStringWithLength(reader, maxConferenceNickSize)
+			if err != nil {
+				return err
+			}
+
+			conf.Peers = append(conf.Peers, peer)
+		}
+
+		s.Conferences = append(s.Conferences, conf)
+	}
+
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (s *sectionConferences) MarshalBinary() ([]byte, error) {
+	buff := new(bytes.Buffer)
+
+	for _, conf := range s.Conferences {
+		err := buff.WriteByte(byte(conf.Type))
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = buff.Write(conf.ID[:])
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.LittleEndian, conf.MessageNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.LittleEndian, conf.LossyMessageNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.LittleEndian, conf.PeerNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		err = binary.Write(buff, binary.LittleEndian, uint32(len(conf.Peers)))
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeStringWithLength(buff, conf.Title, maxConferenceTitleSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, peer := range conf.Peers {
+			_, err = buff.Write(peer.PublicKey[:])
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = buff.Write(peer.TempPublicKey[:])
+			if err != nil {
+				return nil, err
+			}
+
+			err = binary.Write(buff, binary.LittleEndian, peer.PeerNumber)
+			if err != nil {
+				return nil, err
+			}
+
+			err = binary.Write(buff, binary.LittleEndian, peer.LastActive)
+			if err != nil {
+				return nil, err
+			}
+
+			err = writeStringWithLength(buff, peer.Nick, maxConferenceNickSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return buff.Bytes(), nil
+}
+
+// readStringWithLength reads a string that is prefixed with its length as a
+// single byte.
+func readStringWithLength(reader *bytes.Reader, maxSize int) (string, error) {
+	size, err := util.ReadByte(reader)
+	if err != nil {
+		return "", err
+	} else if int(size) > maxSize {
+		return "", fmt.Errorf("%w: invalid string size: %d > %d", ErrMalformed, size, maxSize)
+	}
+
+	str := make([]byte, size)
+	if err = util.ReadFull(reader, str); err != nil {
+		return "", err
+	}
+
+	return string(str), nil
+}
+
+// writeStringWithLength writes a string that is prefixed with its length as a
+// single byte.
+func writeStringWithLength(writer io.Writer, str string, maxSize int) error {
+	if len(str) > maxSize {
+		return fmt.Errorf("string too long: %d > %d", len(str), maxSize)
+	}
+
+	_, err := writer.Write(append([]byte{byte(len(str))}, str...))
+	return err
+}
@@ -0,0 +1,171 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/alexbakker/tox4go/internal/util"
+)
+
+// Decoder reads the sections of a state file from a stream, one at a time.
+// Sections are only decoded into their typed value on request, which makes it
+// cheap to scan a state file for specific sections.
+type Decoder struct {
+	reader  *countingReader
+	opts    DecodeOptions
+	started bool
+	err     error
+}
+
+// DecodedSection is a section that was read by a Decoder.
+type DecodedSection struct {
+	Section
+	// Offset is the offset of the section from the start of the state file.
+	Offset int
+
+	opts DecodeOptions
+}
+
+// NewDecoder creates a new decoder that reads a state file from the given
+// reader and enforces the limits in the given options. Encrypted states are
+// rejected with ErrEncrypted.
+func NewDecoder(r io.Reader, opts DecodeOptions) *Decoder {
+	return &Decoder{
+		reader: &countingReader{r: r},
+		opts:   opts.withDefaults(),
+	}
+}
+
+// Next reads the next section of the state file. It returns io.EOF after the
+// end section was read. Once Next returns an error, it keeps returning that
+// error.
+func (d *Decoder) Next() (*DecodedSection, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	section, err := d.next()
+	if err != nil {
+		d.err = err
+		return nil, err
+	}
+
+	return section, nil
+}
+
+func (d *Decoder) next() (*DecodedSection, error) {
+	if !d.started {
+		d.started = true
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	offset := d.reader.n
+	sectionType, sectionBody, err := readSection(d.reader, cookieInner, d.opts.MaxSectionSize)
+	if err != nil {
+		return nil, newParseError(err, offset, sectionType)
+	}
+
+	if err = checkLimit("size", d.opts.MaxSize, d.reader.n); err != nil {
+		return nil, err
+	}
+
+	if sectionType == SectionTypeEnd {
+		return nil, io.EOF
+	}
+
+	return &DecodedSection{
+		Section: Section{Type: sectionType, Body: sectionBody},
+		Offset:  offset,
+		opts:    d.opts,
+	}, nil
+}
+
+func (d *Decoder) readHeader() error {
+	fields := &fieldTracker{pos: d.reader.pos, friend: -1}
+
+	fields.begin("zeroes")
+	zeroes := make([]byte, 4)
+	err := util.ReadFull(d.reader, zeroes)
+	if err != nil {
+		return newParseError(fields.wrap(err), 0, 0)
+	}
+
+	if !bytes.Equal(zeroes, []byte{0, 0, 0, 0}) {
+		if string(zeroes) == encryptedMagic[:len(zeroes)] {
+			return ErrEncrypted
+		}
+		return newParseError(fields.wrap(fmt.Errorf("%w: state file must start with 4 zeroes", ErrMalformed)), 0, 0)
+	}
+
+	fields.begin("global cookie")
+	var cookie uint32
+	err = util.ReadBinary(d.reader, binary.LittleEndian, &cookie)
+	if err != nil {
+		return newParseError(fields.wrap(err), 0, 0)
+	} else if cookie != cookieGlobal {
+		return newParseError(fields.wrap(GlobalCookieError{actual: cookie, expected: cookieGlobal}), 0, 0)
+	}
+
+	return nil
+}
+
+// Name returns a human readable name for the type of the section.
+func (s *DecodedSection) Name() string {
+	return sectionName(s.Type)
+}
+
+// Value decodes the body of the section. The type of the returned value depends
+// on the type of the section:
+//
+//	SectionTypeNospamKeys:    *NospamKeys
+//	SectionTypeDHT:           []*dht.Node
+//	SectionTypeFriends:       []*Friend
+//	SectionTypeName:          string
+//	SectionTypeStatusMessage: string
+//	SectionTypeStatus:        UserStatus
+//	SectionTypeTCPRelay:      []*dht.Node
+//	SectionTypePathNode:      []*dht.Node
+//	SectionTypeConferences:   []*Conference
+//	SectionTypeGroups:        []*Group
+//
+// The body of sections of any other type is returned as is. Unknown
+// sub-sections of the DHT section are only available in the raw body.
+func (s *DecodedSection) Value() (any, error) {
+	var state State
+	if err := state.unmarshalSection(s.Type, s.Body, s.opts); err != nil {
+		return nil, newParseError(err, s.Offset+sectionHeaderSize, s.Type)
+	}
+
+	switch s.Type {
+	case SectionTypeNospamKeys:
+		return &NospamKeys{
+			PublicKey: state.PublicKey,
+			SecretKey: state.SecretKey,
+			Nospam:    state.Nospam,
+		}, nil
+	case SectionTypeDHT:
+		return state.Nodes, nil
+	case SectionTypeFriends:
+		return state.Friends, nil
+	case SectionTypeName:
+		return state.Name, nil
+	case SectionTypeStatusMessage:
+		return state.StatusMessage, nil
+	case SectionTypeStatus:
+		return state.Status, nil
+	case SectionTypeTCPRelay:
+		return state.TCPRelays, nil
+	case SectionTypePathNode:
+		return state.PathNodes, nil
+	case SectionTypeConferences:
+		return state.Conferences, nil
+	case SectionTypeGroups:
+		return state.Groups, nil
+	default:
+		return s.Body, nil
+	}
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDecoderEncoder(t *testing.T) {
+	s := newTestDiffState(t)
+	s.UnknownSections = []*Section{{Type: 100, Body: []byte{0x13, 0x37}}}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buff := new(bytes.Buffer)
+	enc := NewEncoder(buff)
+	dec := NewDecoder(bytes.NewReader(data), DecodeOptions{})
+
+	var types []uint16
+	for {
+		section, err := dec.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, section.Type)
+
+		value, err := section.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if section.Type == SectionTypeFriends && !reflect.DeepEqual(value, s.Friends) {
+			t.Fatalf("bad friends: %v", value)
+		}
+
+		if err = enc.Encode(section.Type, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTypes := []uint16{
+		SectionTypeNospamKeys,
+		SectionTypeFriends,
+		SectionTypeDHT,
+		SectionTypeName,
+		SectionTypeStatusMessage,
+		SectionTypeStatus,
+		100,
+	}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Fatalf("bad section types: expected: %v, actual: %v", expectedTypes, types)
+	}
+	if !bytes.Equal(buff.Bytes(), data) {
+		t.Fatal("re-encoded state is not equal to the original")
+	}
+
+	if err = enc.Encode(SectionTypeName, "tox4go"); err == nil {
+		t.Fatal("encoded a section after close")
+	}
+	if err = NewEncoder(io.Discard).Encode(SectionTypeName, 1); err == nil {
+		t.Fatal("encoded a section with a bad value")
+	}
+
+	var parseErr ParseError
+	dec = NewDecoder(bytes.NewReader(data[:len(data)-2]), DecodeOptions{})
+	for err = nil; err == nil; {
+		_, err = dec.Next()
+	}
+	if !errors.As(err, &parseErr) || !errors.Is(err, ErrTruncated) || parseErr.SectionType != SectionTypeEnd {
+		t.Fatalf("bad error: %v", err)
+	}
+}
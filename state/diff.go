@@ -0,0 +1,239 @@
+package state
+
+import (
+	"net/netip"
+	"reflect"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/dht"
+)
+
+// StateDiff describes the differences between two states.
+type StateDiff struct {
+	KeysChanged          bool
+	NospamChanged        bool
+	NameChanged          bool
+	StatusMessageChanged bool
+	StatusChanged        bool
+	ConferencesChanged   bool
+	GroupsChanged        bool
+
+	// UnknownSectionsChanged is true if the unknown sections or the unknown
+	// sub-sections of the DHT section differ.
+	UnknownSectionsChanged bool
+
+	// AddedFriends contains the friends that are only in the second state.
+	AddedFriends []*Friend
+	// RemovedFriends contains the friends that are only in the first state.
+	RemovedFriends []*Friend
+	// ChangedFriends contains the friends that are in both states, but
+	// differ.
+	ChangedFriends []*FriendDiff
+
+	Nodes     NodesDiff
+	TCPRelays NodesDiff
+	PathNodes NodesDiff
+}
+
+// FriendDiff describes the differences between two versions of a friend.
+type FriendDiff struct {
+	Old *Friend
+	New *Friend
+	// Fields contains the names of the fields that differ.
+	Fields []string
+}
+
+// NodesDiff describes the differences between two lists of nodes. The order of
+// the nodes is not taken into account.
+type NodesDiff struct {
+	// Added contains the nodes that are only in the second list.
+	Added []*dht.Node
+	// Removed contains the nodes that are only in the first list.
+	Removed []*dht.Node
+}
+
+// friendFields describes the fields of a friend, other than its public key,
+// which identifies it. If merge is set, conflicting changes to the field are
+// resolved with it instead of being reported as a conflict.
+var friendFields = []struct {
+	name  string
+	get   func(f *Friend) any
+	set   func(dst *Friend, src *Friend)
+	merge func(dst *Friend, ours *Friend, theirs *Friend)
+}{
+	{
+		name: "status",
+		get:  func(f *Friend) any { return f.Status },
+		set:  func(dst *Friend, src *Friend) { dst.Status = src.Status },
+		// every friend status assumes the ones before it, so the highest one
+		// is the most recent
+		merge: func(dst *Friend, ours *Friend, theirs *Friend) { dst.Status = max(ours.Status, theirs.Status) },
+	},
+	{
+		name: "user status",
+		get:  func(f *Friend) any { return f.UserStatus },
+		set:  func(dst *Friend, src *Friend) { dst.UserStatus = src.UserStatus },
+	},
+	{
+		name: "request message",
+		get:  func(f *Friend) any { return f.RequestMessage },
+		set:  func(dst *Friend, src *Friend) { dst.RequestMessage = src.RequestMessage },
+	},
+	{
+		name: "name",
+		get:  func(f *Friend) any { return f.Name },
+		set:  func(dst *Friend, src *Friend) { dst.Name = src.Name },
+	},
+	{
+		name: "status message",
+		get:  func(f *Friend) any { return f.StatusMessage },
+		set:  func(dst *Friend, src *Friend) { dst.StatusMessage = src.StatusMessage },
+	},
+	{
+		name: "nospam",
+		get:  func(f *Friend) any { return f.Nospam },
+		set:  func(dst *Friend, src *Friend) { dst.Nospam = src.Nospam },
+	},
+	{
+		name:  "last seen",
+		get:   func(f *Friend) any { return f.LastSeen },
+		set:   func(dst *Friend, src *Friend) { dst.LastSeen = src.LastSeen },
+		merge: func(dst *Friend, ours *Friend, theirs *Friend) { dst.LastSeen = max(ours.LastSeen, theirs.LastSeen) },
+	},
+}
+
+// Diff returns the differences between state a and state b. Friends are
+// matched by their public key.
+func Diff(a *State, b *State) *StateDiff {
+	d := &StateDiff{
+		KeysChanged:          !reflect.DeepEqual(a.PublicKey, b.PublicKey) || !reflect.DeepEqual(a.SecretKey, b.SecretKey),
+		NospamChanged:        a.Nospam != b.Nospam,
+		NameChanged:          a.Name != b.Name,
+		StatusMessageChanged: a.StatusMessage != b.StatusMessage,
+		StatusChanged:        a.Status != b.Status,
+		ConferencesChanged:   !reflect.DeepEqual(a.Conferences, b.Conferences),
+		GroupsChanged:        !reflect.DeepEqual(a.Groups, b.Groups),
+		UnknownSectionsChanged: !reflect.DeepEqual(a.UnknownSections, b.UnknownSections) ||
+			!reflect.DeepEqual(a.UnknownDHTSections, b.UnknownDHTSections),
+		Nodes:     diffNodes(a.Nodes, b.Nodes),
+		TCPRelays: diffNodes(a.TCPRelays, b.TCPRelays),
+		PathNodes: diffNodes(a.PathNodes, b.PathNodes),
+	}
+
+	friendsA := friendMap(a.Friends)
+	friendsB := friendMap(b.Friends)
+	for _, friend := range a.Friends {
+		if _, ok := friendsB[friendKey(friend)]; !ok {
+			d.RemovedFriends = append(d.RemovedFriends, friend)
+		}
+	}
+	for _, friend := range b.Friends {
+		old, ok := friendsA[friendKey(friend)]
+		if !ok {
+			d.AddedFriends = append(d.AddedFriends, friend)
+		} else if fields := diffFriend(old, friend); len(fields) > 0 {
+			d.ChangedFriends = append(d.ChangedFriends, &FriendDiff{Old: old, New: friend, Fields: fields})
+		}
+	}
+
+	return d
+}
+
+// Empty reports whether there are no differences.
+func (d *StateDiff) Empty() bool {
+	return !d.KeysChanged && !d.NospamChanged && !d.NameChanged &&
+		!d.StatusMessageChanged && !d.StatusChanged && !d.ConferencesChanged &&
+		!d.GroupsChanged && !d.UnknownSectionsChanged &&
+		len(d.AddedFriends) == 0 && len(d.RemovedFriends) == 0 && len(d.ChangedFriends) == 0 &&
+		d.Nodes.Empty() && d.TCPRelays.Empty() && d.PathNodes.Empty()
+}
+
+// Empty reports whether there are no differences.
+func (d NodesDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// diffFriend returns the names of the fields that differ between the given
+// friends.
+func diffFriend(a *Friend, b *Friend) []string {
+	var fields []string
+	for _, field := range friendFields {
+		if field.get(a) != field.get(b) {
+			fields = append(fields, field.name)
+		}
+	}
+
+	return fields
+}
+
+func diffNodes(a []*dht.Node, b []*dht.Node) NodesDiff {
+	var d NodesDiff
+	nodesA := nodeSet(a)
+	nodesB := nodeSet(b)
+
+	for _, node := range a {
+		if _, ok := nodesB[newNodeKey(node)]; !ok {
+			d.Removed = append(d.Removed, node)
+		}
+	}
+	for _, node := range b {
+		if _, ok := nodesA[newNodeKey(node)]; !ok {
+			d.Added = append(d.Added, node)
+		}
+	}
+
+	return d
+}
+
+// friendKey returns the public key of the given friend, or the zero key if the
+// friend doesn't have one.
+func friendKey(f *Friend) [crypto.PublicKeySize]byte {
+	if f.PublicKey == nil {
+		return [crypto.PublicKeySize]byte{}
+	}
+
+	return *f.PublicKey
+}
+
+func friendMap(friends []*Friend) map[[crypto.PublicKeySize]byte]*Friend {
+	m := make(map[[crypto.PublicKeySize]byte]*Friend, len(friends))
+	for _, friend := range friends {
+		m[friendKey(friend)] = friend
+	}
+
+	return m
+}
+
+// nodeKey identifies a node in a node list.
+type nodeKey struct {
+	typ  dht.NodeType
+	ip   netip.Addr
+	port int
+	// rawIP is only set if the IP of the node has an invalid length
+	rawIP     string
+	publicKey dht.PublicKey
+}
+
+func newNodeKey(n *dht.Node) nodeKey {
+	key := nodeKey{typ: n.Type, port: n.Port}
+	// IPv4 addresses can be in the 4-byte or the 16-byte form
+	if ip, ok := netip.AddrFromSlice(n.IP); ok {
+		key.ip = ip.Unmap()
+	} else {
+		key.rawIP = string(n.IP)
+	}
+	if n.PublicKey != nil {
+		key.publicKey = *n.PublicKey
+	}
+
+	return key
+}
+
+func nodeSet(nodes []*dht.Node) map[nodeKey]struct{} {
+	set := make(map[nodeKey]struct{}, len(nodes))
+	for _, node := range nodes {
+		set[newNodeKey(node)] = struct{}{}
+	}
+
+	return set
+}
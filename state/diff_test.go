@@ -0,0 +1,132 @@
+package state
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/dht"
+)
+
+func newTestDiffState(t *testing.T) *State {
+	s := newTestState(t)
+	for i := 0; i < 3; i++ {
+		s.Friends = append(s.Friends, &Friend{
+			Status:    FriendStatusConfirmed,
+			PublicKey: &[crypto.PublicKeySize]byte{byte(i)},
+			Name:      "friend",
+		})
+		s.Nodes = append(s.Nodes, &dht.Node{
+			Type:      dht.NodeTypeUDPIP4,
+			PublicKey: &dht.PublicKey{byte(i)},
+			IP:        net.IPv4(127, 0, 0, byte(i)).To4(),
+			Port:      33445,
+		})
+	}
+
+	return s
+}
+
+// copyState returns a copy of the given state that can be changed without
+// changing the original.
+func copyState(t *testing.T, s *State) *State {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c State
+	if err = c.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return &c
+}
+
+func TestDiff(t *testing.T) {
+	a := newTestDiffState(t)
+	b := copyState(t, a)
+	if d := Diff(a, b); !d.Empty() {
+		t.Fatalf("unexpected diff: %+v", d)
+	}
+
+	b.Name = "other"
+	b.Friends[0].Name = "other"
+	b.Friends = b.Friends[:2]
+	b.Friends = append(b.Friends, &Friend{PublicKey: &[crypto.PublicKeySize]byte{3}})
+	b.Nodes = b.Nodes[1:]
+
+	d := Diff(a, b)
+	if !d.NameChanged || d.KeysChanged || d.StatusMessageChanged {
+		t.Fatalf("bad diff: %+v", d)
+	}
+	if len(d.AddedFriends) != 1 || d.AddedFriends[0].PublicKey[0] != 3 {
+		t.Fatalf("bad added friends: %v", d.AddedFriends)
+	}
+	if len(d.RemovedFriends) != 1 || d.RemovedFriends[0].PublicKey[0] != 2 {
+		t.Fatalf("bad removed friends: %v", d.RemovedFriends)
+	}
+	if len(d.ChangedFriends) != 1 || !reflect.DeepEqual(d.ChangedFriends[0].Fields, []string{"name"}) {
+		t.Fatalf("bad changed friends: %v", d.ChangedFriends)
+	}
+	if len(d.Nodes.Removed) != 1 || len(d.Nodes.Added) != 0 || d.Nodes.Removed[0] != a.Nodes[0] {
+		t.Fatalf("bad node diff: %+v", d.Nodes)
+	}
+
+	// the 4-byte and 16-byte forms of an IPv4 address are the same address
+	b = copyState(t, a)
+	for _, node := range b.Nodes {
+		node.IP = node.IP.To16()
+	}
+	if d = Diff(a, b); !d.Nodes.Empty() {
+		t.Fatalf("bad node diff: %+v", d.Nodes)
+	}
+	merged, _ := Merge(a, a, b)
+	if !reflect.DeepEqual(merged.Nodes, a.Nodes) {
+		t.Fatalf("bad merged nodes: %v", merged.Nodes)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := newTestDiffState(t)
+
+	ours := copyState(t, base)
+	ours.Name = "ours"
+	ours.Friends[0].Name = "ours"
+	ours.Friends[1].LastSeen = 1
+	ours.Friends = append(ours.Friends, &Friend{PublicKey: &[crypto.PublicKeySize]byte{3}})
+
+	theirs := copyState(t, base)
+	theirs.StatusMessage = "theirs"
+	theirs.Friends[0].Name = "theirs"
+	theirs.Friends[1].LastSeen = 2
+	theirs.Friends[1].Nospam = 1
+	theirs.Friends = theirs.Friends[:2]
+	theirs.Nodes = theirs.Nodes[1:]
+
+	merged, conflicts := Merge(base, ours, theirs)
+	expectedConflicts := []Conflict{{Field: "name", Friend: base.Friends[0].PublicKey}}
+	if !reflect.DeepEqual(conflicts, expectedConflicts) {
+		t.Fatalf("bad conflicts: expected: %v, actual: %v", expectedConflicts, conflicts)
+	}
+
+	if merged.Name != "ours" || merged.StatusMessage != "theirs" {
+		t.Fatalf("bad merged state: %+v", merged)
+	}
+	if n := len(merged.Friends); n != 3 {
+		t.Fatalf("bad amount of friends: expected: %d, actual: %d", 3, n)
+	}
+	if f := merged.Friends[0]; f.Name != "ours" {
+		t.Fatalf("bad friend: %+v", f)
+	}
+	if f := merged.Friends[1]; f.LastSeen != 2 || f.Nospam != 1 {
+		t.Fatalf("bad friend: %+v", f)
+	}
+	if f := merged.Friends[2]; f.PublicKey[0] != 3 {
+		t.Fatalf("bad friend: %+v", f)
+	}
+	if !Diff(theirs, merged).Nodes.Empty() {
+		t.Fatalf("bad merged nodes: %v", merged.Nodes)
+	}
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/alexbakker/tox4go/crypto"
+)
+
+var (
+	// ErrStringTooLong is returned when setting a string that doesn't fit in
+	// the state file.
+	ErrStringTooLong = errors.New("string too long")
+
+	// ErrInvalidUTF8 is returned when setting a string that is not valid
+	// UTF-8.
+	ErrInvalidUTF8 = errors.New("string is not valid UTF-8")
+
+	// ErrNoMessage is returned when adding a friend without a friend request
+	// message.
+	ErrNoMessage = errors.New("friend request message is empty")
+
+	// ErrOwnPublicKey is returned when adding our own public key as a friend.
+	ErrOwnPublicKey = errors.New("public key is our own")
+
+	// ErrFriendExists is returned when adding a friend that is already in the
+	// friend list.
+	ErrFriendExists = errors.New("friend already exists")
+
+	// ErrFriendNotFound is returned when removing a friend that is not in the
+	// friend list.
+	ErrFriendNotFound = errors.New("friend not found")
+)
+
+// ToxID returns the Tox ID of the state. The public key of the state must be
+// set.
+func (s *State) ToxID() ToxID {
+	return NewToxID(s.PublicKey, s.Nospam)
+}
+
+// SetNospam changes the nospam value, which results in a new Tox ID. Friend
+// requests sent to the old Tox ID are no longer accepted.
+func (s *State) SetNospam(nospam uint32) {
+	s.Nospam = nospam
+}
+
+// RegenerateNospam changes the nospam value to a random one.
+func (s *State) RegenerateNospam() error {
+	var nospam [4]byte
+	if _, err := rand.Read(nospam[:]); err != nil {
+		return err
+	}
+
+	s.SetNospam(binary.LittleEndian.Uint32(nospam[:]))
+	return nil
+}
+
+// SetName changes our name.
+func (s *State) SetName(name string) error {
+	if err := checkString("name", name, maxNameSize); err != nil {
+		return err
+	}
+
+	s.Name = name
+	return nil
+}
+
+// SetStatusMessage changes our status message.
+func (s *State) SetStatusMessage(statusMessage string) error {
+	if err := checkString("status message", statusMessage, maxStatusMessageSize); err != nil {
+		return err
+	}
+
+	s.StatusMessage = statusMessage
+	return nil
+}
+
+// AddFriend adds the owner of the given Tox ID to the friend list, with the
+// given friend request message. The friend request is sent by the client the
+// next time it loads the state.
+func (s *State) AddFriend(id ToxID, message string) (*Friend, error) {
+	if err := id.Verify(); err != nil {
+		return nil, err
+	}
+	if message == "" {
+		return nil, ErrNoMessage
+	}
+	if err := checkString("friend request message", message, maxRequestMessageSize); err != nil {
+		return nil, err
+	}
+
+	publicKey := id.PublicKey()
+	if s.PublicKey != nil && *publicKey == *s.PublicKey {
+		return nil, ErrOwnPublicKey
+	}
+	if s.friendIndex(publicKey) != -1 {
+		return nil, ErrFriendExists
+	}
+
+	friend := &Friend{
+		Status:         FriendStatusAdded,
+		PublicKey:      publicKey,
+		RequestMessage: message,
+		Nospam:         id.Nospam(),
+	}
+	s.Friends = append(s.Friends, friend)
+	return friend, nil
+}
+
+// RemoveFriend removes the friend with the given public key from the friend
+// list.
+func (s *State) RemoveFriend(publicKey *[crypto.PublicKeySize]byte) error {
+	i := s.friendIndex(publicKey)
+	if i == -1 {
+		return ErrFriendNotFound
+	}
+
+	s.Friends = append(s.Friends[:i], s.Friends[i+1:]...)
+	return nil
+}
+
+// friendIndex returns the index of the friend with the given public key, or -1
+// if there is no such friend.
+func (s *State) friendIndex(publicKey *[crypto.PublicKeySize]byte) int {
+	for i, friend := range s.Friends {
+		if friend.PublicKey != nil && *friend.PublicKey == *publicKey {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// checkString checks that the given string is valid UTF-8 and that it's not
+// larger than maxSize bytes.
+func checkString(name string, str string, maxSize int) error {
+	if len(str) > maxSize {
+		return fmt.Errorf("%w: %s: %d > %d", ErrStringTooLong, name, len(str), maxSize)
+	}
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("%w: %s", ErrInvalidUTF8, name)
+	}
+
+	return nil
+}
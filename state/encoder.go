@@ -0,0 +1,176 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/alexbakker/tox4go/dht"
+)
+
+var errEncoderClosed = errors.New("encoder is closed")
+
+// Encoder writes the sections of a state file to a stream, one at a time. The
+// header of the state file is written along with the first section, and the
+// end section is written by Close.
+type Encoder struct {
+	writer  io.Writer
+	started bool
+	closed  bool
+}
+
+// NewEncoder creates a new encoder that writes a state file to the given
+// writer.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{writer: w}
+}
+
+// Encode writes a section of the given type with the given value as its body.
+// It accepts the same types of values that DecodedSection.Value returns.
+func (e *Encoder) Encode(sectionType uint16, value any) error {
+	body, err := marshalSection(sectionType, value)
+	if err != nil {
+		return err
+	}
+
+	return e.WriteSection(sectionType, body)
+}
+
+// WriteSection writes a section of the given type with the given raw body.
+func (e *Encoder) WriteSection(sectionType uint16, body []byte) error {
+	if sectionType == SectionTypeEnd {
+		return errors.New("the end section is written by Close")
+	}
+
+	return e.writeSection(sectionType, body)
+}
+
+// Close writes the end section. It doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	if err := e.writeSection(SectionTypeEnd, []byte{}); err != nil {
+		return err
+	}
+
+	e.closed = true
+	return nil
+}
+
+func (e *Encoder) writeSection(sectionType uint16, body []byte) error {
+	if e.closed {
+		return errEncoderClosed
+	}
+
+	if !e.started {
+		//write the first 4 zero bytes
+		_, err := e.writer.Write([]byte{0, 0, 0, 0})
+		if err != nil {
+			return err
+		}
+
+		//write the global cookie
+		err = binary.Write(e.writer, binary.LittleEndian, uint32(cookieGlobal))
+		if err != nil {
+			return err
+		}
+
+		e.started = true
+	}
+
+	return writeSection(e.writer, sectionType, cookieInner, body)
+}
+
+// marshalSection encodes the given value as the body of a section of the given
+// type.
+func marshalSection(sectionType uint16, value any) ([]byte, error) {
+	switch sectionType {
+	case SectionTypeNospamKeys:
+		if v, ok := value.(*NospamKeys); ok {
+			return v.MarshalBinary()
+		}
+	case SectionTypeDHT:
+		if v, ok := value.([]*dht.Node); ok {
+			return marshalDHT(v, nil)
+		}
+	case SectionTypeFriends:
+		if v, ok := value.([]*Friend); ok {
+			section := sectionFriends{Friends: v}
+			return section.MarshalBinary()
+		}
+	case SectionTypeName, SectionTypeStatusMessage:
+		if v, ok := value.(string); ok {
+			return []byte(v), nil
+		}
+	case SectionTypeStatus:
+		if v, ok := value.(UserStatus); ok {
+			return []byte{byte(v)}, nil
+		}
+	case SectionTypeTCPRelay, SectionTypePathNode:
+		if v, ok := value.([]*dht.Node); ok {
+			section := sectionNodes{Nodes: v}
+			return section.MarshalBinary()
+		}
+	case SectionTypeConferences:
+		if v, ok := value.([]*Conference); ok {
+			section := sectionConferences{Conferences: v}
+			return section.MarshalBinary()
+		}
+	case SectionTypeGroups:
+		if v, ok := value.([]*Group); ok {
+			section := sectionGroups{Groups: v}
+			return section.MarshalBinary()
+		}
+	default:
+		if v, ok := value.([]byte); ok {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("bad value for %s section (type %d): %T", sectionName(sectionType), sectionType, value)
+}
+
+// marshalDHT encodes the body of the DHT section, which consists of
+// sub-sections of its own.
+func marshalDHT(nodes []*dht.Node, unknownSections []*Section) ([]byte, error) {
+	buff := new(bytes.Buffer)
+
+	err := binary.Write(buff, binary.LittleEndian, uint32(cookieDHTGlobal))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, section := range unknownSections {
+		if section.Before != dhtSectionTypeNodes {
+			continue
+		}
+		err = writeSection(buff, section.Type, cookieDHTInner, section.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(nodes) > 0 {
+		nodesSection := sectionNodes{Nodes: nodes}
+		body, err := nodesSection.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		err = writeSection(buff, dhtSectionTypeNodes, cookieDHTInner, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, section := range unknownSections {
+		if section.Before == dhtSectionTypeNodes {
+			continue
+		}
+		err = writeSection(buff, section.Type, cookieDHTInner, section.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buff.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package state
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EncryptionExtraLength is the amount of bytes an encrypted state is larger
+	// than the state it contains.
+	EncryptionExtraLength = len(encryptedMagic) + SaltSize + crypto.NonceSize + macSize
+
+	// SaltSize is the size of the salt that is used to derive the key of an
+	// encrypted state in bytes.
+	SaltSize = 32
+
+	// PassKeySize is the size of a key that is derived from a passphrase in
+	// bytes.
+	PassKeySize = 32
+
+	encryptedMagic = "toxEsave"
+	macSize        = 16
+
+	// These are the scrypt parameters that libsodium picks for twice the
+	// interactive opslimit and the interactive memlimit, as used by c-toxcore.
+	scryptN = 1 << 14
+	scryptR = 8
+	scryptP = 2
+)
+
+var (
+	// ErrEncrypted is returned when trying to unmarshal an encrypted state
+	// without decrypting it first.
+	ErrEncrypted = errors.New("state is encrypted")
+
+	// ErrNotEncrypted is returned when trying to decrypt a state that is not
+	// encrypted.
+	ErrNotEncrypted = errors.New("state is not encrypted")
+
+	// ErrDecryptionFailed is returned when an encrypted state could not be
+	// decrypted, because the passphrase is wrong or the data is corrupted.
+	ErrDecryptionFailed = errors.New("decryption failed: wrong passphrase or corrupted data")
+)
+
+// PassKey is a key derived from a passphrase and a salt. Deriving a key is
+// slow on purpose, so a PassKey can be reused to encrypt a state multiple times
+// with the same passphrase.
+type PassKey struct {
+	Salt [SaltSize]byte
+	Key  [PassKeySize]byte
+}
+
+// NewPassKey derives a new key from the given passphrase with a random salt.
+func NewPassKey(passphrase []byte) (*PassKey, error) {
+	var salt [SaltSize]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return nil, err
+	}
+
+	return DerivePassKey(passphrase, &salt)
+}
+
+// DerivePassKey derives a key from the given passphrase and salt. The key is
+// derived the same way as c-toxcore does it: the SHA-256 hash of the
+// passphrase is passed to scrypt.
+func DerivePassKey(passphrase []byte, salt *[SaltSize]byte) (*PassKey, error) {
+	hash := sha256.Sum256(passphrase)
+	key, err := scrypt.Key(hash[:], salt[:], scryptN, scryptR, scryptP, PassKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+
+	passKey := PassKey{Salt: *salt}
+	copy(passKey.Key[:], key)
+	return &passKey, nil
+}
+
+// Encrypt encrypts the given data with the key. The salt of the key is stored
+// in the result, so that the data can be decrypted with just the passphrase.
+func (k *PassKey) Encrypt(data []byte) ([]byte, error) {
+	encData, nonce, err := crypto.Encrypt(data, &k.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(data)+EncryptionExtraLength)
+	buf = append(buf, encryptedMagic...)
+	buf = append(buf, k.Salt[:]...)
+	buf = append(buf, nonce[:]...)
+	return append(buf, encData...), nil
+}
+
+// Decrypt decrypts the given encrypted data with the key. The data must have
+// been encrypted with a key with the same salt.
+func (k *PassKey) Decrypt(data []byte) ([]byte, error) {
+	salt, err := Salt(data)
+	if err != nil {
+		return nil, err
+	}
+	if *salt != k.Salt {
+		return nil, ErrDecryptionFailed
+	}
+
+	var nonce [crypto.NonceSize]byte
+	offset := len(encryptedMagic) + SaltSize
+	copy(nonce[:], data[offset:])
+
+	res, err := crypto.Decrypt(data[offset+crypto.NonceSize:], &k.Key, &nonce)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return res, nil
+}
+
+// IsEncrypted reports whether the given data is an encrypted state.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedMagic))
+}
+
+// Salt returns the salt that was used to derive the key of the given encrypted
+// data.
+func Salt(data []byte) (*[SaltSize]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	if len(data) < EncryptionExtraLength {
+		return nil, fmt.Errorf("%w: encrypted state too short: %d < %d", ErrTruncated, len(data), EncryptionExtraLength)
+	}
+
+	var salt [SaltSize]byte
+	copy(salt[:], data[len(encryptedMagic):])
+	return &salt, nil
+}
+
+// Encrypt encrypts the given data with a key derived from the given
+// passphrase. The format is compatible with tox_pass_encrypt of c-toxcore.
+func Encrypt(data []byte, passphrase []byte) ([]byte, error) {
+	key, err := NewPassKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Encrypt(data)
+}
+
+// Decrypt decrypts the given encrypted data with a key derived from the given
+// passphrase. The format is compatible with tox_pass_decrypt of c-toxcore.
+func Decrypt(data []byte, passphrase []byte) ([]byte, error) {
+	salt, err := Salt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DerivePassKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Decrypt(data)
+}
+
+// MarshalEncrypted marshals the state and encrypts it with a key derived from
+// the given passphrase.
+func (s *State) MarshalEncrypted(passphrase []byte) ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return Encrypt(data, passphrase)
+}
+
+// UnmarshalEncrypted decrypts the given data with a key derived from the given
+// passphrase and unmarshals the result into the state.
+func (s *State) UnmarshalEncrypted(data []byte, passphrase []byte) error {
+	data, err := Decrypt(data, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return s.UnmarshalBinary(data)
+}
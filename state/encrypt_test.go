@@ -0,0 +1,121 @@
+package state
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestEncryptedState(t *testing.T) {
+	s := newTestState(t)
+	plain, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passphrase := []byte("correct horse battery staple")
+	data, err := s.MarshalEncrypted(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncrypted(data) {
+		t.Fatal("state is not encrypted")
+	}
+	if len(data) != len(plain)+EncryptionExtraLength {
+		t.Fatalf("bad encrypted size: expected: %d, actual: %d", len(plain)+EncryptionExtraLength, len(data))
+	}
+
+	if err = new(State).UnmarshalBinary(data); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrEncrypted, err)
+	}
+	if err = new(State).UnmarshalEncrypted(data, []byte("wrong")); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrDecryptionFailed, err)
+	}
+
+	var res State
+	if err = res.UnmarshalEncrypted(data, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if *res.SecretKey != *s.SecretKey || res.Nospam != s.Nospam || res.Name != s.Name {
+		t.Fatal("decrypted state does not match")
+	}
+
+	// Encrypting again with the same key should only result in a different
+	// nonce and ciphertext
+	salt, err := Salt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := DerivePassKey(passphrase, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data2, err := key.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:len(encryptedMagic)+SaltSize], data2[:len(encryptedMagic)+SaltSize]) {
+		t.Fatal("salt does not match")
+	}
+	if res2, err := key.Decrypt(data2); err != nil || !bytes.Equal(res2, plain) {
+		t.Fatalf("bad decryption: %v", err)
+	}
+}
+
+func TestEncryptedStateKnownAnswer(t *testing.T) {
+	// Encrypted with the passphrase below in the same way as c-toxcore's
+	// tox_pass_encrypt: with libsodium's crypto_pwhash_scryptsalsa208sha256 at
+	// twice the interactive opslimit and the interactive memlimit, and
+	// crypto_box_afternm. The salt and nonce are fixed.
+	const (
+		passphrase = "correct horse battery staple"
+		encrypted  = "746f784573617665404142434445464748494a4b4c4d4e4f5051525354555657" +
+			"58595a5b5c5d5e5f808182838485868788898a8b8c8d8e8f9091929394959697" +
+			"0c0472fd1a41201bac79dbf269182d5856833592cc8b81a4f2b27752770147c0" +
+			"5b139cb8f74f4dbc097386e381bab8492007c143ce713508863d35ce1ddd840b" +
+			"12b0d59082d8a2f6d08f77b93f84305040963549af438b20d5acefd8a6a31db9" +
+			"fa8a3a022f0b228a5dfa3229576d53646020dd96bb709df562a2521df1d76015" +
+			"3ea3809be6ac32043f47cc"
+		plain = "000000001f1bed15440000000100ce010403020107a37cbc142093c8b755dc1b" +
+			"10e86cb426374ad16aa853ed0bdfc0b2b86d1c7c0102030405060708090a0b0c" +
+			"0d0e0f101112131415161718191a1b1c1d1e1f20060000000400ce01746f7834" +
+			"676f000000000500ce01010000000600ce010000000000ff00ce01"
+	)
+
+	data, err := hex.DecodeString(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := hex.DecodeString(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt, err := Salt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range salt {
+		if b != byte(0x40+i) {
+			t.Fatalf("bad salt: %x", salt)
+		}
+	}
+
+	res, err := Decrypt(data, []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res, expected) {
+		t.Fatalf("bad decrypted state: expected: %x, actual: %x", expected, res)
+	}
+
+	var s State
+	if err = s.UnmarshalEncrypted(data, []byte(passphrase)); err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "tox4go" || s.Nospam != 0x01020304 {
+		t.Fatalf("bad decrypted state: name: %q, nospam: %x", s.Name, s.Nospam)
+	}
+}
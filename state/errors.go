@@ -1,6 +1,23 @@
 package state
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alexbakker/tox4go/internal/util"
+)
+
+var (
+	// ErrTruncated is returned when decoding a state file that ends before
+	// all fields could be read.
+	ErrTruncated = util.ErrTruncated
+	// ErrTrailingData is returned when decoding a section of a state file that
+	// has data left after all fields were read.
+	ErrTrailingData = util.ErrTrailingData
+	// ErrMalformed is returned when decoding a state file with a field that
+	// has an invalid value.
+	ErrMalformed = util.ErrMalformed
+)
 
 // GlobalCookieError represents an error that occurs during a magic number check.
 // It provides the value it expected and the value that it actually found.
@@ -25,3 +42,200 @@ func (e InnerCookieError) Error() string {
 	return fmt.Sprintf("incorrect inner cookie: 0x%x (should be 0x%x)",
 		e.actual, e.expected)
 }
+
+// Unwrap returns ErrMalformed, so that cookie errors can be detected with
+// errors.Is(err, ErrMalformed).
+func (e GlobalCookieError) Unwrap() error {
+	return ErrMalformed
+}
+
+// Unwrap returns ErrMalformed, so that cookie errors can be detected with
+// errors.Is(err, ErrMalformed).
+func (e InnerCookieError) Unwrap() error {
+	return ErrMalformed
+}
+
+// ParseError is returned when decoding a state file fails. It describes where
+// in the state file decoding failed, so that corrupted state files can be
+// diagnosed. The underlying error is available through errors.Is and
+// errors.As.
+type ParseError struct {
+	// Offset is the offset in bytes from the start of the state file of the
+	// field that could not be decoded.
+	Offset int
+	// SectionType is the type of the section that could not be decoded. It is
+	// zero if decoding failed before the type of a section was known, for
+	// example in the header of the state file.
+	SectionType uint16
+	// SectionName is the name of the section that could not be decoded. It is
+	// empty if SectionType is zero.
+	SectionName string
+	// Friend is the index of the friend that could not be decoded, or -1 if
+	// decoding didn't fail in the friends section.
+	Friend int
+	// Field is the name of the field that could not be decoded. It may be
+	// empty if the error doesn't concern a specific field.
+	Field string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e ParseError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "bad state at offset %d", e.Offset)
+	if e.SectionName != "" {
+		fmt.Fprintf(&b, ": %s section (type %d)", e.SectionName, e.SectionType)
+	}
+	if e.Friend >= 0 {
+		fmt.Fprintf(&b, ": friend %d", e.Friend)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, ": %s", e.Field)
+	}
+	fmt.Fprintf(&b, ": %s", e.Err)
+	return b.String()
+}
+
+// Unwrap returns the underlying error.
+func (e ParseError) Unwrap() error {
+	return e.Err
+}
+
+// sectionName returns a human readable name for the given section type.
+func sectionName(sectionType uint16) string {
+	switch sectionType {
+	case SectionTypeNospamKeys:
+		return "nospam and keys"
+	case SectionTypeDHT:
+		return "dht"
+	case SectionTypeFriends:
+		return "friends"
+	case SectionTypeName:
+		return "name"
+	case SectionTypeStatusMessage:
+		return "status message"
+	case SectionTypeStatus:
+		return "status"
+	case SectionTypeTCPRelay:
+		return "tcp relays"
+	case SectionTypePathNode:
+		return "path nodes"
+	case SectionTypeConferences:
+		return "conferences"
+	case SectionTypeGroups:
+		return "groups"
+	case SectionTypeEnd:
+		return "end"
+	default:
+		return "unknown"
+	}
+}
+
+// fieldError annotates an error that occurred while decoding part of a state
+// file with the field that was being decoded. The offset is relative to the
+// start of the data that was being decoded. It is turned into a ParseError by
+// State.UnmarshalBinary.
+type fieldError struct {
+	offset int
+	friend int
+	field  string
+	err    error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("offset %d: %s: %s", e.offset, e.field, e.err)
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// fieldTracker keeps track of the field that is being decoded from a reader,
+// so that errors can be annotated with the name and offset of that field.
+type fieldTracker struct {
+	// pos returns the current offset of the reader
+	pos func() int
+
+	// prefix is prepended to the name of every field, to identify the item
+	// the field belongs to
+	prefix string
+	friend int
+	offset int
+	field  string
+}
+
+// newFieldTracker creates a new field tracker for a reader of which the amount
+// of unread bytes is known.
+func newFieldTracker(reader interface{ Len() int }) *fieldTracker {
+	size := reader.Len()
+	return &fieldTracker{
+		pos:    func() int { return size - reader.Len() },
+		friend: -1,
+	}
+}
+
+// begin marks the start of the given field at the current offset of the
+// reader.
+func (t *fieldTracker) begin(field string) {
+	t.offset = t.pos()
+	t.field = field
+}
+
+// wrap annotates the given error with the field that was last begun. It
+// returns nil if the error is nil.
+func (t *fieldTracker) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &fieldError{
+		offset: t.offset,
+		friend: t.friend,
+		field:  t.prefix + t.field,
+		err:    err,
+	}
+}
+
+// nestFieldError adjusts an error that occurred while decoding data found at
+// the given offset, so that it becomes relative to the enclosing data. The
+// given prefix is prepended to the name of the field.
+func nestFieldError(err error, offset int, prefix string) error {
+	if err == nil {
+		return nil
+	}
+
+	fieldErr, ok := err.(*fieldError)
+	if !ok {
+		return &fieldError{offset: offset, friend: -1, field: strings.TrimSuffix(prefix, ": "), err: err}
+	}
+
+	return &fieldError{
+		offset: offset + fieldErr.offset,
+		friend: fieldErr.friend,
+		field:  prefix + fieldErr.field,
+		err:    fieldErr.err,
+	}
+}
+
+// newParseError converts an error that occurred while decoding the section of
+// the given type at the given offset into a ParseError.
+func newParseError(err error, offset int, sectionType uint16) error {
+	parseErr := ParseError{
+		Offset:      offset,
+		SectionType: sectionType,
+		Friend:      -1,
+		Err:         err,
+	}
+	if sectionType != 0 {
+		parseErr.SectionName = sectionName(sectionType)
+	}
+
+	if fieldErr, ok := err.(*fieldError); ok {
+		parseErr.Offset += fieldErr.offset
+		parseErr.Friend = fieldErr.friend
+		parseErr.Field = fieldErr.field
+		parseErr.Err = fieldErr.err
+	}
+
+	return parseErr
+}
@@ -3,8 +3,10 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/internal/util"
 )
 
 // Friend represents the structure of friends that can be found inside a Tox
@@ -20,65 +22,90 @@ type Friend struct {
 	LastSeen       uint64
 }
 
+// ToxID returns the Tox ID that the friend was added with. The nospam value is
+// only known for friends that were added by us.
+func (f *Friend) ToxID() ToxID {
+	return NewToxID(f.PublicKey, f.Nospam)
+}
+
 type sectionFriends struct {
 	Friends []*Friend
+
+	maxFriends int
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
-func (s *sectionFriends) UnmarshalBinary(data []byte) error {
+func (s *sectionFriends) UnmarshalBinary(data []byte) (err error) {
 	reader := bytes.NewReader(data)
+	fields := newFieldTracker(reader)
+	defer func() { err = fields.wrap(err) }()
 
 	for reader.Len() > 0 {
+		fields.friend = len(s.Friends)
+		fields.begin("")
+		if err = checkLimit("friends", s.maxFriends, len(s.Friends)+1); err != nil {
+			return err
+		}
+
 		friend := new(Friend)
 
-		friendStatus, err := reader.ReadByte()
+		fields.begin("status")
+		friendStatus, err := util.ReadByte(reader)
 		if err != nil {
 			return err
 		}
 		friend.Status = FriendStatus(friendStatus)
 
+		fields.begin("public key")
 		friend.PublicKey = new([crypto.PublicKeySize]byte)
-		_, err = reader.Read(friend.PublicKey[:])
+		err = util.ReadFull(reader, friend.PublicKey[:])
 		if err != nil {
 			return err
 		}
 
+		fields.begin("request message")
 		reqMessage, err := readStringWithSize(reader, maxRequestMessageSize, 1)
 		if err != nil {
 			return err
 		}
 		friend.RequestMessage = reqMessage
 
+		fields.begin("name")
 		name, err := readStringWithSize(reader, maxNameSize, 0)
 		if err != nil {
 			return err
 		}
 		friend.Name = name
 
+		fields.begin("status message")
 		statusMessage, err := readStringWithSize(reader, maxStatusMessageSize, 1)
 		if err != nil {
 			return err
 		}
 		friend.StatusMessage = statusMessage
 
-		userStatus, err := reader.ReadByte()
+		fields.begin("user status")
+		userStatus, err := util.ReadByte(reader)
 		if err != nil {
 			return err
 		}
 		friend.UserStatus = UserStatus(userStatus)
 
 		//skip padding
-		_, err = reader.Seek(3, 1)
+		fields.begin("padding")
+		err = util.ReadFull(reader, make([]byte, 3))
 		if err != nil {
 			return err
 		}
 
-		err = binary.Read(reader, binary.LittleEndian, &friend.Nospam)
+		fields.begin("nospam")
+		err = util.ReadBinary(reader, binary.LittleEndian, &friend.Nospam)
 		if err != nil {
 			return err
 		}
 
-		err = binary.Read(reader, binary.BigEndian, &friend.LastSeen)
+		fields.begin("last seen")
+		err = util.ReadBinary(reader, binary.BigEndian, &friend.LastSeen)
 		if err != nil {
 			return err
 		}
@@ -92,7 +119,7 @@ func (s *sectionFriends) UnmarshalBinary(data []byte) error {
 func (s *sectionFriends) MarshalBinary() ([]byte, error) {
 	buff := new(bytes.Buffer)
 
-	for _, friend := range s.Friends {
+	for i, friend := range s.Friends {
 		err := buff.WriteByte(byte(friend.Status))
 		if err != nil {
 			return nil, err
@@ -105,17 +132,17 @@ func (s *sectionFriends) MarshalBinary() ([]byte, error) {
 
 		err = writeStringWithSize(buff, friend.RequestMessage, maxRequestMessageSize, 1)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("friend %d: request message: %w", i, err)
 		}
 
 		err = writeStringWithSize(buff, friend.Name, maxNameSize, 0)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("friend %d: name: %w", i, err)
 		}
 
 		err = writeStringWithSize(buff, friend.StatusMessage, maxStatusMessageSize, 1)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("friend %d: status message: %w", i, err)
 		}
 
 		err = buff.WriteByte(byte(friend.UserStatus))
@@ -0,0 +1,525 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/alexbakker/tox4go/internal/msgpack"
+)
+
+type (
+	// GroupPrivacyState represents the privacy state of a group.
+	GroupPrivacyState byte
+	// GroupVoiceState represents which roles are allowed to send messages to a
+	// group.
+	GroupVoiceState byte
+	// GroupTopicLock represents whether the topic of a group is locked.
+	GroupTopicLock uint32
+	// GroupRole represents the role of a peer in a group.
+	GroupRole byte
+)
+
+const (
+	// GroupPrivacyStatePublic indicates that the group can be found and
+	// joined by anyone who knows its chat ID.
+	GroupPrivacyStatePublic GroupPrivacyState = iota
+	// GroupPrivacyStatePrivate indicates that the group can only be joined
+	// through an invite from a friend.
+	GroupPrivacyStatePrivate
+)
+
+const (
+	// GroupVoiceStateAll indicates that all peers except observers can send
+	// messages.
+	GroupVoiceStateAll GroupVoiceState = iota
+	// GroupVoiceStateModerator indicates that only moderators and the founder
+	// can send messages.
+	GroupVoiceStateModerator
+	// GroupVoiceStateFounder indicates that only the founder can send messages.
+	GroupVoiceStateFounder
+)
+
+const (
+	// GroupTopicLockEnabled indicates that only the founder and moderators can
+	// set the topic.
+	GroupTopicLockEnabled GroupTopicLock = iota
+	// GroupTopicLockDisabled indicates that all peers except observers can set
+	// the topic.
+	GroupTopicLockDisabled
+)
+
+const (
+	// GroupRoleFounder indicates the founder of the group.
+	GroupRoleFounder GroupRole = iota
+	// GroupRoleModerator indicates a moderator of the group.
+	GroupRoleModerator
+	// GroupRoleUser indicates a regular peer of the group.
+	GroupRoleUser
+	// GroupRoleObserver indicates a peer that can't send messages or change
+	// the topic.
+	GroupRoleObserver
+)
+
+const (
+	// GroupExtPublicKeySize is the size of an extended public key in bytes. It
+	// consists of an encryption public key and a signature public key.
+	GroupExtPublicKeySize = 64
+	// GroupExtSecretKeySize is the size of an extended secret key in bytes. It
+	// consists of an encryption secret key and a signature secret key.
+	GroupExtSecretKeySize = 96
+	// GroupSigPublicKeySize is the size of a signature public key in bytes.
+	GroupSigPublicKeySize = 32
+	// GroupSignatureSize is the size of a signature in bytes.
+	GroupSignatureSize = 64
+	// GroupModListHashSize is the size of the hash of the moderator list in
+	// bytes.
+	GroupModListHashSize = 32
+)
+
+// Group represents the structure of NGC groups that can be found inside a Tox
+// state file.
+type Group struct {
+	// Disconnected indicates that we disconnected from the group and won't
+	// reconnect to it until we explicitly rejoin.
+	Disconnected bool
+
+	SharedState          GroupSharedState
+	SharedStateSignature *[GroupSignatureSize]byte
+	Topic                GroupTopic
+	// Moderators contains the signature public keys of the moderators.
+	Moderators []*[GroupSigPublicKeySize]byte
+
+	// ChatPublicKey is the public key of the group, of which the first 32
+	// bytes are the chat ID. ChatSecretKey is only known to the founder.
+	ChatPublicKey *[GroupExtPublicKeySize]byte
+	ChatSecretKey *[GroupExtSecretKeySize]byte
+	SelfPublicKey *[GroupExtPublicKeySize]byte
+	SelfSecretKey *[GroupExtSecretKeySize]byte
+
+	Self       GroupSelf
+	SavedPeers GroupSavedPeers
+}
+
+// GroupSharedState represents the state of a group that is shared with all of
+// its peers and signed by the founder.
+type GroupSharedState struct {
+	FounderPublicKey *[GroupExtPublicKeySize]byte
+	MaxPeers         uint16
+	Name             string
+	PrivacyState     GroupPrivacyState
+	Password         string
+	// ModListHash is the SHA-256 hash of the moderator list.
+	ModListHash *[GroupModListHashSize]byte
+	Version     uint32
+	TopicLock   GroupTopicLock
+	VoiceState  GroupVoiceState
+}
+
+// GroupTopic represents the topic of a group and the signature of the peer
+// that set it.
+type GroupTopic struct {
+	Version   uint32
+	Checksum  uint16
+	Topic     string
+	PublicKey *[GroupSigPublicKeySize]byte
+	Signature *[GroupSignatureSize]byte
+}
+
+// GroupSelf represents our own peer in a group.
+type GroupSelf struct {
+	Nick   string
+	Role   GroupRole
+	Status UserStatus
+}
+
+// GroupSavedPeers represents the peers of a group we were connected to, which
+// Tox clients try to reconnect to first when rejoining the group.
+type GroupSavedPeers struct {
+	Count uint16
+	// Data contains the packed saved peers as is.
+	Data []byte
+}
+
+type sectionGroups struct {
+	Groups []*Group
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (s *sectionGroups) UnmarshalBinary(data []byte) (err error) {
+	reader := msgpack.NewReader(data)
+	fields := newFieldTracker(reader)
+	defer func() { err = fields.wrap(err) }()
+
+	fields.begin("group count")
+	count, err := reader.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		group := new(Group)
+		fields.prefix = fmt.Sprintf("group %d: ", i)
+		if err = group.unpack(reader, fields); err != nil {
+			return err
+		}
+
+		s.Groups = append(s.Groups, group)
+	}
+
+	fields.prefix = ""
+	fields.begin("")
+	if reader.Len() != 0 {
+		return fmt.Errorf("%w: %d unexpected extra bytes", ErrTrailingData, reader.Len())
+	}
+
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (s *sectionGroups) MarshalBinary() ([]byte, error) {
+	var writer msgpack.Writer
+	writer.WriteArrayHeader(len(s.Groups))
+
+	for i, group := range s.Groups {
+		if err := group.pack(&writer); err != nil {
+			return nil, fmt.Errorf("group %d: %w", i, err)
+		}
+	}
+
+	return writer.Bytes(), nil
+}
+
+func (g *Group) unpack(r *msgpack.Reader, fields *fieldTracker) error {
+	fields.begin("group")
+	if err := r.ReadArray(7); err != nil {
+		return err
+	}
+
+	// state values
+	fields.begin("state values")
+	if err := r.ReadArray(8); err != nil {
+		return err
+	}
+
+	var err error
+	if g.Disconnected, err = r.ReadBool(); err != nil {
+		return err
+	}
+
+	nameSize, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+
+	privacyState, err := r.ReadUint(8)
+	if err != nil {
+		return err
+	}
+	g.SharedState.PrivacyState = GroupPrivacyState(privacyState)
+
+	maxPeers, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+	g.SharedState.MaxPeers = uint16(maxPeers)
+
+	passwordSize, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+
+	version, err := r.ReadUint(32)
+	if err != nil {
+		return err
+	}
+	g.SharedState.Version = uint32(version)
+
+	topicLock, err := r.ReadUint(32)
+	if err != nil {
+		return err
+	}
+	g.SharedState.TopicLock = GroupTopicLock(topicLock)
+
+	voiceState, err := r.ReadUint(8)
+	if err != nil {
+		return err
+	}
+	g.SharedState.VoiceState = GroupVoiceState(voiceState)
+
+	// shared state
+	fields.begin("shared state")
+	if err = r.ReadArray(5); err != nil {
+		return err
+	}
+
+	g.SharedStateSignature = new([GroupSignatureSize]byte)
+	if err = readBinFixed(r, "shared state signature", g.SharedStateSignature[:]); err != nil {
+		return err
+	}
+
+	g.SharedState.FounderPublicKey = new([GroupExtPublicKeySize]byte)
+	if err = readBinFixed(r, "founder public key", g.SharedState.FounderPublicKey[:]); err != nil {
+		return err
+	}
+
+	if g.SharedState.Name, err = readBinString(r, "group name", int(nameSize)); err != nil {
+		return err
+	}
+
+	if g.SharedState.Password, err = readBinString(r, "password", int(passwordSize)); err != nil {
+		return err
+	}
+
+	g.SharedState.ModListHash = new([GroupModListHashSize]byte)
+	if err = readBinFixed(r, "moderator list hash", g.SharedState.ModListHash[:]); err != nil {
+		return err
+	}
+
+	// topic info
+	fields.begin("topic info")
+	if err = r.ReadArray(6); err != nil {
+		return err
+	}
+
+	topicVersion, err := r.ReadUint(32)
+	if err != nil {
+		return err
+	}
+	g.Topic.Version = uint32(topicVersion)
+
+	topicSize, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+
+	topicChecksum, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+	g.Topic.Checksum = uint16(topicChecksum)
+
+	if g.Topic.Topic, err = readBinString(r, "topic", int(topicSize)); err != nil {
+		return err
+	}
+
+	g.Topic.PublicKey = new([GroupSigPublicKeySize]byte)
+	if err = readBinFixed(r, "topic public key", g.Topic.PublicKey[:]); err != nil {
+		return err
+	}
+
+	g.Topic.Signature = new([GroupSignatureSize]byte)
+	if err = readBinFixed(r, "topic signature", g.Topic.Signature[:]); err != nil {
+		return err
+	}
+
+	// moderator list
+	fields.begin("moderator list")
+	if err = r.ReadArray(2); err != nil {
+		return err
+	}
+
+	modCount, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+
+	mods, err := r.ReadBin()
+	if err != nil {
+		return err
+	} else if len(mods) != int(modCount)*GroupSigPublicKeySize {
+		return fmt.Errorf("%w: bad moderator list size: %d (should be %d)", ErrMalformed, len(mods), int(modCount)*GroupSigPublicKeySize)
+	}
+
+	for i := 0; i < int(modCount); i++ {
+		mod := new([GroupSigPublicKeySize]byte)
+		copy(mod[:], mods[i*GroupSigPublicKeySize:])
+		g.Moderators = append(g.Moderators, mod)
+	}
+
+	// keys
+	fields.begin("keys")
+	if err = r.ReadArray(4); err != nil {
+		return err
+	}
+
+	g.ChatPublicKey = new([GroupExtPublicKeySize]byte)
+	if err = readBinFixed(r, "chat public key", g.ChatPublicKey[:]); err != nil {
+		return err
+	}
+
+	g.ChatSecretKey = new([GroupExtSecretKeySize]byte)
+	if err = readBinFixed(r, "chat secret key", g.ChatSecretKey[:]); err != nil {
+		return err
+	}
+
+	g.SelfPublicKey = new([GroupExtPublicKeySize]byte)
+	if err = readBinFixed(r, "self public key", g.SelfPublicKey[:]); err != nil {
+		return err
+	}
+
+	g.SelfSecretKey = new([GroupExtSecretKeySize]byte)
+	if err = readBinFixed(r, "self secret key", g.SelfSecretKey[:]); err != nil {
+		return err
+	}
+
+	// self info
+	fields.begin("self info")
+	if err = r.ReadArray(4); err != nil {
+		return err
+	}
+
+	nickSize, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+
+	role, err := r.ReadUint(8)
+	if err != nil {
+		return err
+	}
+	g.Self.Role = GroupRole(role)
+
+	status, err := r.ReadUint(8)
+	if err != nil {
+		return err
+	}
+	g.Self.Status = UserStatus(status)
+
+	if g.Self.Nick, err = readBinString(r, "nick", int(nickSize)); err != nil {
+		return err
+	}
+
+	// saved peers
+	fields.begin("saved peers")
+	if err = r.ReadArray(2); err != nil {
+		return err
+	}
+
+	savedPeerCount, err := r.ReadUint(16)
+	if err != nil {
+		return err
+	}
+	g.SavedPeers.Count = uint16(savedPeerCount)
+
+	savedPeers, err := r.ReadBin()
+	if err != nil {
+		return err
+	}
+	if len(savedPeers) > 0 {
+		g.SavedPeers.Data = append([]byte{}, savedPeers...)
+	}
+
+	return nil
+}
+
+func (g *Group) pack(w *msgpack.Writer) error {
+	for _, field := range []struct {
+		name string
+		size int
+	}{
+		{"group name", len(g.SharedState.Name)},
+		{"password", len(g.SharedState.Password)},
+		{"topic", len(g.Topic.Topic)},
+		{"nick", len(g.Self.Nick)},
+		{"moderator list", len(g.Moderators)},
+	} {
+		if field.size > 0xffff {
+			return fmt.Errorf("%s too long: %d", field.name, field.size)
+		}
+	}
+
+	w.WriteArrayHeader(7)
+
+	// state values
+	w.WriteArrayHeader(8)
+	w.WriteBool(g.Disconnected)
+	w.WriteUint(uint64(len(g.SharedState.Name)))
+	w.WriteUint(uint64(g.SharedState.PrivacyState))
+	w.WriteUint(uint64(g.SharedState.MaxPeers))
+	w.WriteUint(uint64(len(g.SharedState.Password)))
+	w.WriteUint(uint64(g.SharedState.Version))
+	w.WriteUint(uint64(g.SharedState.TopicLock))
+	w.WriteUint(uint64(g.SharedState.VoiceState))
+
+	// shared state
+	w.WriteArrayHeader(5)
+	w.WriteBin(g.SharedStateSignature[:])
+	w.WriteBin(g.SharedState.FounderPublicKey[:])
+	w.WriteBin([]byte(g.SharedState.Name))
+	w.WriteBin([]byte(g.SharedState.Password))
+	w.WriteBin(g.SharedState.ModListHash[:])
+
+	// topic info
+	w.WriteArrayHeader(6)
+	w.WriteUint(uint64(g.Topic.Version))
+	w.WriteUint(uint64(len(g.Topic.Topic)))
+	w.WriteUint(uint64(g.Topic.Checksum))
+	w.WriteBin([]byte(g.Topic.Topic))
+	w.WriteBin(g.Topic.PublicKey[:])
+	w.WriteBin(g.Topic.Signature[:])
+
+	// moderator list
+	w.WriteArrayHeader(2)
+	w.WriteUint(uint64(len(g.Moderators)))
+	if len(g.Moderators) == 0 {
+		w.WriteNil()
+	} else {
+		mods := make([]byte, 0, len(g.Moderators)*GroupSigPublicKeySize)
+		for _, mod := range g.Moderators {
+			mods = append(mods, mod[:]...)
+		}
+		w.WriteBin(mods)
+	}
+
+	// keys
+	w.WriteArrayHeader(4)
+	w.WriteBin(g.ChatPublicKey[:])
+	w.WriteBin(g.ChatSecretKey[:])
+	w.WriteBin(g.SelfPublicKey[:])
+	w.WriteBin(g.SelfSecretKey[:])
+
+	// self info
+	w.WriteArrayHeader(4)
+	w.WriteUint(uint64(len(g.Self.Nick)))
+	w.WriteUint(uint64(g.Self.Role))
+	w.WriteUint(uint64(g.Self.Status))
+	w.WriteBin([]byte(g.Self.Nick))
+
+	// saved peers
+	w.WriteArrayHeader(2)
+	w.WriteUint(uint64(g.SavedPeers.Count))
+	// c-toxcore writes nil instead of empty binary data if there are no saved
+	// peers
+	if len(g.SavedPeers.Data) == 0 {
+		w.WriteNil()
+	} else {
+		w.WriteBin(g.SavedPeers.Data)
+	}
+
+	return nil
+}
+
+// readBinFixed reads binary data into dst, which must be of the exact same
+// size.
+func readBinFixed(r *msgpack.Reader, name string, dst []byte) error {
+	data, err := r.ReadBin()
+	if err != nil {
+		return err
+	} else if len(data) != len(dst) {
+		return fmt.Errorf("%w: bad %s size: %d (should be %d)", ErrMalformed, name, len(data), len(dst))
+	}
+
+	copy(dst, data)
+	return nil
+}
+
+// readBinString reads binary data of the given size as a string.
+func readBinString(r *msgpack.Reader, name string, size int) (string, error) {
+	data, err := r.ReadBin()
+	if err != nil {
+		return "", err
+	} else if len(data) != size {
+		return "", fmt.Errorf("%w: bad %s size: %d (should be %d)", ErrMalformed, name, len(data), size)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultMaxSize is the default maximum size of a state file in bytes.
+	DefaultMaxSize = 64 << 20
+
+	// DefaultMaxSectionSize is the default maximum size of a single section
+	// of a state file in bytes.
+	DefaultMaxSectionSize = DefaultMaxSize
+
+	// DefaultMaxFriends is the default maximum amount of friends in a state
+	// file.
+	DefaultMaxFriends = 1 << 16
+
+	// DefaultMaxNodes is the default maximum amount of nodes in each of the
+	// node lists of a state file.
+	DefaultMaxNodes = 1 << 16
+)
+
+// ErrLimitExceeded is wrapped by LimitError.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// DecodeOptions contains the limits that are enforced while decoding a state
+// file. They protect against state files that are crafted to use up a lot of
+// memory or CPU time.
+type DecodeOptions struct {
+	// MaxSize is the maximum size of the state file in bytes. If zero,
+	// DefaultMaxSize is used. If negative, there is no limit.
+	MaxSize int
+	// MaxSectionSize is the maximum size of a single section in bytes. If
+	// zero, DefaultMaxSectionSize is used. If negative, there is no limit.
+	MaxSectionSize int
+	// MaxFriends is the maximum amount of friends. If zero, DefaultMaxFriends
+	// is used. If negative, there is no limit.
+	MaxFriends int
+	// MaxNodes is the maximum amount of nodes in each of the node lists:
+	// DHT nodes, TCP relays and path nodes. If zero, DefaultMaxNodes is used.
+	// If negative, there is no limit.
+	MaxNodes int
+}
+
+// LimitError is returned when decoding a state file that exceeds one of the
+// limits in DecodeOptions.
+type LimitError struct {
+	// Limit is the name of the limit that was exceeded.
+	Limit string
+	// Max is the value of the limit.
+	Max int
+	// Actual is the value that exceeded the limit.
+	Actual int
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("state %s limit exceeded: %d > %d", e.Limit, e.Actual, e.Max)
+}
+
+// Unwrap returns ErrLimitExceeded, so that limit errors can be detected with
+// errors.Is(err, ErrLimitExceeded).
+func (e LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// withDefaults returns a copy of the options with the zero values replaced by
+// their defaults.
+func (o DecodeOptions) withDefaults() DecodeOptions {
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultMaxSize
+	}
+	if o.MaxSectionSize == 0 {
+		o.MaxSectionSize = DefaultMaxSectionSize
+	}
+	if o.MaxFriends == 0 {
+		o.MaxFriends = DefaultMaxFriends
+	}
+	if o.MaxNodes == 0 {
+		o.MaxNodes = DefaultMaxNodes
+	}
+	return o
+}
+
+// checkLimit returns a LimitError if the given value exceeds the given limit.
+// Limits that are not positive are ignored.
+func checkLimit(limit string, max int, actual int) error {
+	if max > 0 && actual > max {
+		return LimitError{Limit: limit, Max: max, Actual: actual}
+	}
+	return nil
+}
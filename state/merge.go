@@ -0,0 +1,220 @@
+package state
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/dht"
+)
+
+// Conflict describes a field that was changed in different ways in both states
+// given to Merge.
+type Conflict struct {
+	// Field is the name of the field that was changed in both states.
+	Field string
+	// Friend is the public key of the friend the field belongs to, or nil if
+	// the field doesn't belong to a friend.
+	Friend *[crypto.PublicKeySize]byte
+}
+
+func (c Conflict) String() string {
+	if c.Friend != nil {
+		return fmt.Sprintf("friend %X: %s", c.Friend[:], c.Field)
+	}
+
+	return c.Field
+}
+
+// stateFields describes the fields of a state that are merged as a whole.
+var stateFields = []struct {
+	name string
+	get  func(s *State) any
+	set  func(dst *State, src *State)
+}{
+	{
+		name: "keys",
+		get:  func(s *State) any { return []any{s.PublicKey, s.SecretKey} },
+		set: func(dst *State, src *State) {
+			dst.PublicKey = src.PublicKey
+			dst.SecretKey = src.SecretKey
+		},
+	},
+	{
+		name: "nospam",
+		get:  func(s *State) any { return s.Nospam },
+		set:  func(dst *State, src *State) { dst.Nospam = src.Nospam },
+	},
+	{
+		name: "name",
+		get:  func(s *State) any { return s.Name },
+		set:  func(dst *State, src *State) { dst.Name = src.Name },
+	},
+	{
+		name: "status message",
+		get:  func(s *State) any { return s.StatusMessage },
+		set:  func(dst *State, src *State) { dst.StatusMessage = src.StatusMessage },
+	},
+	{
+		name: "status",
+		get:  func(s *State) any { return s.Status },
+		set:  func(dst *State, src *State) { dst.Status = src.Status },
+	},
+	{
+		name: "conferences",
+		get:  func(s *State) any { return s.Conferences },
+		set:  func(dst *State, src *State) { dst.Conferences = src.Conferences },
+	},
+	{
+		name: "groups",
+		get:  func(s *State) any { return s.Groups },
+		set:  func(dst *State, src *State) { dst.Groups = src.Groups },
+	},
+	{
+		name: "unknown sections",
+		get:  func(s *State) any { return s.UnknownSections },
+		set:  func(dst *State, src *State) { dst.UnknownSections = src.UnknownSections },
+	},
+	{
+		name: "unknown dht sections",
+		get:  func(s *State) any { return s.UnknownDHTSections },
+		set:  func(dst *State, src *State) { dst.UnknownDHTSections = src.UnknownDHTSections },
+	},
+}
+
+// Merge reconciles two states that were both derived from the given base
+// state, for example a profile that was edited on two different devices.
+//
+// Changes that were only made in one of the states are applied to the result.
+// Friends are matched by their public key and merged field by field. Friends
+// that were added in either state are kept, and friends that were removed in
+// one state are removed, unless they were changed in the other state. Node
+// lists are merged as sets. The most recent friend status and last seen time
+// are always picked for friends that were changed in both states.
+//
+// Any other field that was changed in different ways in both states is
+// reported as a Conflict, in which case the value from ours is kept. The
+// returned state may share data with the given states.
+func Merge(base *State, ours *State, theirs *State) (*State, []Conflict) {
+	merged := *ours
+	var conflicts []Conflict
+
+	for _, field := range stateFields {
+		baseValue, ourValue, theirValue := field.get(base), field.get(ours), field.get(theirs)
+		switch {
+		case reflect.DeepEqual(ourValue, theirValue), reflect.DeepEqual(baseValue, theirValue):
+		case reflect.DeepEqual(baseValue, ourValue):
+			field.set(&merged, theirs)
+		default:
+			conflicts = append(conflicts, Conflict{Field: field.name})
+		}
+	}
+
+	merged.Nodes = mergeNodes(base.Nodes, ours.Nodes, theirs.Nodes)
+	merged.TCPRelays = mergeNodes(base.TCPRelays, ours.TCPRelays, theirs.TCPRelays)
+	merged.PathNodes = mergeNodes(base.PathNodes, ours.PathNodes, theirs.PathNodes)
+
+	var friendConflicts []Conflict
+	merged.Friends, friendConflicts = mergeFriends(base.Friends, ours.Friends, theirs.Friends)
+	conflicts = append(conflicts, friendConflicts...)
+
+	return &merged, conflicts
+}
+
+func mergeFriends(base []*Friend, ours []*Friend, theirs []*Friend) ([]*Friend, []Conflict) {
+	var merged []*Friend
+	var conflicts []Conflict
+
+	baseFriends := friendMap(base)
+	ourFriends := friendMap(ours)
+	theirFriends := friendMap(theirs)
+
+	for _, ourFriend := range ours {
+		key := friendKey(ourFriend)
+		baseFriend, inBase := baseFriends[key]
+		theirFriend, inTheirs := theirFriends[key]
+
+		switch {
+		case inTheirs:
+			friend, fields := mergeFriend(baseFriend, ourFriend, theirFriend)
+			for _, field := range fields {
+				conflicts = append(conflicts, Conflict{Field: field, Friend: ourFriend.PublicKey})
+			}
+			merged = append(merged, friend)
+		case !inBase:
+			// added by us
+			merged = append(merged, ourFriend)
+		case len(diffFriend(baseFriend, ourFriend)) > 0:
+			// removed by them, but changed by us
+			conflicts = append(conflicts, Conflict{Field: "removed", Friend: ourFriend.PublicKey})
+			merged = append(merged, ourFriend)
+		}
+	}
+
+	for _, theirFriend := range theirs {
+		key := friendKey(theirFriend)
+		if _, ok := ourFriends[key]; ok {
+			continue
+		}
+
+		baseFriend, inBase := baseFriends[key]
+		switch {
+		case !inBase:
+			// added by them
+			merged = append(merged, theirFriend)
+		case len(diffFriend(baseFriend, theirFriend)) > 0:
+			// removed by us, but changed by them
+			conflicts = append(conflicts, Conflict{Field: "removed", Friend: theirFriend.PublicKey})
+		}
+	}
+
+	return merged, conflicts
+}
+
+// mergeFriend merges two versions of a friend field by field and returns the
+// names of the fields that conflict. The base version is nil if the friend was
+// added in both states.
+func mergeFriend(base *Friend, ours *Friend, theirs *Friend) (*Friend, []string) {
+	merged := *ours
+	var conflicts []string
+
+	for _, field := range friendFields {
+		ourValue, theirValue := field.get(ours), field.get(theirs)
+		switch {
+		case ourValue == theirValue:
+		case base != nil && field.get(base) == theirValue:
+		case base != nil && field.get(base) == ourValue:
+			field.set(&merged, theirs)
+		case field.merge != nil:
+			field.merge(&merged, ours, theirs)
+		default:
+			conflicts = append(conflicts, field.name)
+		}
+	}
+
+	return &merged, conflicts
+}
+
+// mergeNodes applies the changes that were made to the base node list in
+// theirs to ours.
+func mergeNodes(base []*dht.Node, ours []*dht.Node, theirs []*dht.Node) []*dht.Node {
+	d := diffNodes(base, theirs)
+	removed := nodeSet(d.Removed)
+
+	var merged []*dht.Node
+	seen := make(map[nodeKey]struct{}, len(ours))
+	for _, node := range ours {
+		key := newNodeKey(node)
+		if _, ok := removed[key]; !ok {
+			merged = append(merged, node)
+			seen[key] = struct{}{}
+		}
+	}
+	for _, node := range d.Added {
+		if _, ok := seen[newNodeKey(node)]; !ok {
+			merged = append(merged, node)
+		}
+	}
+
+	return merged
+}
@@ -3,13 +3,13 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 	"net"
 
 	"github.com/alexbakker/tox4go/crypto"
 	"github.com/alexbakker/tox4go/dht"
+	"github.com/alexbakker/tox4go/internal/util"
 )
 
 type (
@@ -45,24 +45,34 @@ const (
 	FriendStatusOnline
 )
 
+// These are the types of the sections of a state file.
+const (
+	SectionTypeNospamKeys    = 1
+	SectionTypeDHT           = 2
+	SectionTypeFriends       = 3
+	SectionTypeName          = 4
+	SectionTypeStatusMessage = 5
+	SectionTypeStatus        = 6
+	SectionTypeTCPRelay      = 10
+	SectionTypePathNode      = 11
+	SectionTypeConferences   = 20
+	SectionTypeGroups        = 21
+	// SectionTypeEnd marks the end of a state file. It has no body.
+	SectionTypeEnd = 0xFF
+)
+
 const (
 	cookieGlobal    = 0x15ED1B1F
 	cookieDHTGlobal = 0x159000D
 	cookieInner     = 0x01CE
 	cookieDHTInner  = 0x11CE
 
-	sectionTypeNospamKeys    = 1
-	sectionTypeDHT           = 2
-	sectionTypeFriends       = 3
-	sectionTypeName          = 4
-	sectionTypeStatusMessage = 5
-	sectionTypeStatus        = 6
-	sectionTypeTCPRelay      = 10
-	sectionTypePathNode      = 11
-	sectionTypeEnd           = 0xFF
-
 	dhtSectionTypeNodes = 4
 
+	// sectionHeaderSize is the size of the length, type and cookie that
+	// precede the body of every section
+	sectionHeaderSize = 8
+
 	maxNameSize           = 128
 	maxStatusMessageSize  = 1007
 	maxRequestMessageSize = 1024
@@ -82,9 +92,33 @@ type State struct {
 	Nodes     []*dht.Node
 	TCPRelays []*dht.Node
 	PathNodes []*dht.Node
+
+	Conferences []*Conference
+	Groups      []*Group
+
+	// UnknownSections contains the sections this package doesn't know how to
+	// parse, in the order they were found in. They are written back as is, at
+	// the position indicated by their Before field.
+	UnknownSections []*Section
+	// UnknownDHTSections contains the sub-sections of the DHT section this
+	// package doesn't know how to parse, in the order they were found in. They
+	// are written back as is, at the position indicated by their Before field.
+	UnknownDHTSections []*Section
 }
 
-type sectionNospamKeys struct {
+// Section represents a raw section of a state file.
+type Section struct {
+	Type uint16
+	Body []byte
+	// Before is the type of the known section that this unknown section was
+	// found in front of. It's written back in front of that section. If
+	// Before is 0 or the type of a section this package doesn't know, it's
+	// written after all known sections.
+	Before uint16
+}
+
+// NospamKeys is the value of the nospam and keys section of a state file.
+type NospamKeys struct {
 	PublicKey *[crypto.PublicKeySize]byte
 	SecretKey *[crypto.PublicKeySize]byte
 	Nospam    uint32
@@ -92,259 +126,368 @@ type sectionNospamKeys struct {
 
 type sectionNodes struct {
 	Nodes []*dht.Node
+
+	maxNodes int
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
 func (s *State) MarshalBinary() ([]byte, error) {
 	buff := new(bytes.Buffer)
-	dhtBuff := new(bytes.Buffer)
-
-	//write the first 4 zero bytes
-	_, err := buff.Write([]byte{0, 0, 0, 0})
-	if err != nil {
-		return nil, err
+	enc := NewEncoder(buff)
+
+	// writeUnknown writes the unknown sections that belong in front of the
+	// known section of the given type
+	writeUnknown := func(before uint16) error {
+		for _, section := range s.UnknownSections {
+			if section.Before == before {
+				if err := enc.WriteSection(section.Type, section.Body); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
 	}
 
-	//write the global cookie
-	err = binary.Write(buff, binary.LittleEndian, uint32(cookieGlobal))
-	if err != nil {
+	//write SectionTypeNospamKeys
+	if err := writeUnknown(SectionTypeNospamKeys); err != nil {
 		return nil, err
 	}
-
-	//write sectionTypeNospamKeys
-	nospamSection := sectionNospamKeys{
+	err := enc.Encode(SectionTypeNospamKeys, &NospamKeys{
 		PublicKey: s.PublicKey,
 		SecretKey: s.SecretKey,
 		Nospam:    s.Nospam,
-	}
-	bytes, err := nospamSection.MarshalBinary()
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = writeSection(buff, sectionTypeNospamKeys, cookieInner, bytes)
-	if err != nil {
+
+	//write SectionTypeFriends
+	if err = writeUnknown(SectionTypeFriends); err != nil {
 		return nil, err
 	}
-
-	//write sectionTypeFriends
 	if len(s.Friends) > 0 {
-		friendsSection := sectionFriends{Friends: s.Friends}
-		bytes, err = friendsSection.MarshalBinary()
-		if err != nil {
-			return nil, err
-		}
-		err = writeSection(buff, sectionTypeFriends, cookieInner, bytes)
-		if err != nil {
+		if err = enc.Encode(SectionTypeFriends, s.Friends); err != nil {
 			return nil, err
 		}
 	}
 
-	//write sectionTypePathNode
+	//write SectionTypePathNode
+	if err = writeUnknown(SectionTypePathNode); err != nil {
+		return nil, err
+	}
 	if len(s.PathNodes) > 0 {
-		pathNodeSection := sectionNodes{Nodes: s.PathNodes}
-		bytes, err = pathNodeSection.MarshalBinary()
-		if err != nil {
-			return nil, err
-		}
-		err = writeSection(buff, sectionTypePathNode, cookieInner, bytes)
-		if err != nil {
+		if err = enc.Encode(SectionTypePathNode, s.PathNodes); err != nil {
 			return nil, err
 		}
 	}
 
-	//write sectionTypeTCPRelay
+	//write SectionTypeTCPRelay
+	if err = writeUnknown(SectionTypeTCPRelay); err != nil {
+		return nil, err
+	}
 	if len(s.TCPRelays) > 0 {
-		tcpRelaySection := sectionNodes{Nodes: s.TCPRelays}
-		bytes, err = tcpRelaySection.MarshalBinary()
-		if err != nil {
-			return nil, err
-		}
-		err = writeSection(buff, sectionTypeTCPRelay, cookieInner, bytes)
-		if err != nil {
+		if err = enc.Encode(SectionTypeTCPRelay, s.TCPRelays); err != nil {
 			return nil, err
 		}
 	}
 
-	//write sectionTypeDHT and dhtSectionTypeNodes
-	if len(s.Nodes) > 0 {
-		err = binary.Write(dhtBuff, binary.LittleEndian, uint32(cookieDHTGlobal))
-		if err != nil {
-			return nil, err
-		}
-		nodesSection := sectionNodes{Nodes: s.Nodes}
-		bytes, err = nodesSection.MarshalBinary()
+	//write SectionTypeDHT, dhtSectionTypeNodes and unknown dht sections
+	if err = writeUnknown(SectionTypeDHT); err != nil {
+		return nil, err
+	}
+	if len(s.Nodes) > 0 || len(s.UnknownDHTSections) > 0 {
+		body, err := marshalDHT(s.Nodes, s.UnknownDHTSections)
 		if err != nil {
 			return nil, err
 		}
-		err = writeSection(dhtBuff, dhtSectionTypeNodes, cookieDHTInner, bytes)
-		if err != nil {
-			return nil, err
-		}
-		err = writeSection(buff, sectionTypeDHT, cookieInner, dhtBuff.Bytes())
-		if err != nil {
+		if err = enc.WriteSection(SectionTypeDHT, body); err != nil {
 			return nil, err
 		}
 	}
 
-	//write sectionTypeName
-	bytes = []byte(s.Name)
-	err = writeSection(buff, sectionTypeName, cookieInner, bytes)
-	if err != nil {
+	//write SectionTypeName
+	if err = writeUnknown(SectionTypeName); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(SectionTypeName, s.Name); err != nil {
 		return nil, err
 	}
 
-	//write sectionTypeStatusMessage
-	bytes = []byte(s.StatusMessage)
-	err = writeSection(buff, sectionTypeStatusMessage, cookieInner, bytes)
-	if err != nil {
+	//write SectionTypeStatusMessage
+	if err = writeUnknown(SectionTypeStatusMessage); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(SectionTypeStatusMessage, s.StatusMessage); err != nil {
 		return nil, err
 	}
 
-	//write sectionTypeStatus
-	bytes = []byte{byte(s.Status)}
-	err = writeSection(buff, sectionTypeStatus, cookieInner, bytes)
-	if err != nil {
+	//write SectionTypeStatus
+	if err = writeUnknown(SectionTypeStatus); err != nil {
+		return nil, err
+	}
+	if err = enc.Encode(SectionTypeStatus, s.Status); err != nil {
 		return nil, err
 	}
 
-	//write sectionTypeEnd
-	err = writeSection(buff, sectionTypeEnd, cookieInner, []byte{})
-	if err != nil {
+	//write SectionTypeConferences
+	if err = writeUnknown(SectionTypeConferences); err != nil {
 		return nil, err
 	}
+	if len(s.Conferences) > 0 {
+		if err = enc.Encode(SectionTypeConferences, s.Conferences); err != nil {
+			return nil, err
+		}
+	}
 
-	return buff.Bytes(), err
+	//write SectionTypeGroups
+	if err = writeUnknown(SectionTypeGroups); err != nil {
+		return nil, err
+	}
+	if len(s.Groups) > 0 {
+		if err = enc.Encode(SectionTypeGroups, s.Groups); err != nil {
+			return nil, err
+		}
+	}
+
+	//write the remaining unknown sections
+	for _, section := range s.UnknownSections {
+		if isKnownSection(section.Before) {
+			continue
+		}
+		if err = enc.WriteSection(section.Type, section.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	//write SectionTypeEnd
+	if err = enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
 }
 
-// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface. The
+// default limits of DecodeOptions are enforced. Encrypted states are rejected
+// with ErrEncrypted, use UnmarshalEncrypted for those instead.
 func (s *State) UnmarshalBinary(data []byte) error {
-	reader := bytes.NewReader(data)
+	return s.UnmarshalBinaryWithOptions(data, DecodeOptions{})
+}
 
-	zeroes := make([]byte, 4)
-	_, err := reader.Read(zeroes)
-	if err != nil {
+// UnmarshalBinaryWithOptions is like UnmarshalBinary, but enforces the limits
+// in the given options instead of the default ones.
+func (s *State) UnmarshalBinaryWithOptions(data []byte, opts DecodeOptions) error {
+	if IsEncrypted(data) {
+		return ErrEncrypted
+	}
+
+	opts = opts.withDefaults()
+	if err := checkLimit("size", opts.MaxSize, len(data)); err != nil {
 		return err
 	}
 
-	if !bytes.Equal(zeroes, []byte{0, 0, 0, 0}) {
-		return errors.New("state file must start with 4 zeroes")
+	s.UnknownSections = nil
+	s.UnknownDHTSections = nil
+
+	// the unknown sections that are waiting for a known section to follow them
+	var pending []*Section
+
+	dec := NewDecoder(bytes.NewReader(data), opts)
+	for {
+		section, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err = s.unmarshalSection(section.Type, section.Body, opts); err != nil {
+			return newParseError(err, section.Offset+sectionHeaderSize, section.Type)
+		}
+
+		if !isKnownSection(section.Type) {
+			pending = append(pending, s.UnknownSections[len(s.UnknownSections)-1])
+			continue
+		}
+		for _, unknown := range pending {
+			unknown.Before = section.Type
+		}
+		pending = nil
 	}
+}
 
-	var cookie uint32
-	err = binary.Read(reader, binary.LittleEndian, &cookie)
-	if err != nil {
-		return err
-	} else if cookie != cookieGlobal {
-		return GlobalCookieError{actual: cookie, expected: cookieGlobal}
+// isKnownSection reports whether this package knows how to parse sections of
+// the given type.
+func isKnownSection(sectionType uint16) bool {
+	switch sectionType {
+	case SectionTypeNospamKeys, SectionTypeDHT, SectionTypeFriends, SectionTypeName,
+		SectionTypeStatusMessage, SectionTypeStatus, SectionTypeTCPRelay, SectionTypePathNode,
+		SectionTypeConferences, SectionTypeGroups:
+		return true
+	default:
+		return false
 	}
+}
 
-	for {
-		sectionType, sectionBody, err := readSection(reader, cookieInner)
+// unmarshalSection decodes the body of a section of the given type into the
+// state.
+func (s *State) unmarshalSection(sectionType uint16, sectionBody []byte, opts DecodeOptions) error {
+	switch sectionType {
+	case SectionTypeNospamKeys:
+		section := NospamKeys{}
+		err := section.UnmarshalBinary(sectionBody)
 		if err != nil {
 			return err
-		} else if sectionType == sectionTypeEnd {
-			return nil
 		}
 
-		switch sectionType {
-		case sectionTypeNospamKeys:
-			section := sectionNospamKeys{}
-			err = section.UnmarshalBinary(sectionBody)
-			if err != nil {
-				return err
-			}
+		s.Nospam = section.Nospam
+		s.PublicKey = section.PublicKey
+		s.SecretKey = section.SecretKey
+	case SectionTypeFriends:
+		friendSection := &sectionFriends{maxFriends: opts.MaxFriends}
+		err := friendSection.UnmarshalBinary(sectionBody)
+		if err != nil {
+			return err
+		}
 
-			s.Nospam = section.Nospam
-			s.PublicKey = section.PublicKey
-			s.SecretKey = section.SecretKey
-		case sectionTypeFriends:
-			friendSection := new(sectionFriends)
-			err = friendSection.UnmarshalBinary(sectionBody)
-			if err != nil {
-				return err
-			}
+		s.Friends = friendSection.Friends
+	case SectionTypeName:
+		s.Name = string(sectionBody)
+	case SectionTypeStatusMessage:
+		s.StatusMessage = string(sectionBody)
+	case SectionTypeStatus:
+		if len(sectionBody) != 1 {
+			return fmt.Errorf("%w: bad status section size: %d", ErrMalformed, len(sectionBody))
+		}
 
-			s.Friends = friendSection.Friends
-		case sectionTypeName:
-			s.Name = string(sectionBody)
-		case sectionTypeStatusMessage:
-			s.StatusMessage = string(sectionBody)
-		case sectionTypeStatus:
-			s.Status = UserStatus(sectionBody[0])
-		case sectionTypeTCPRelay:
-			section := sectionNodes{}
-			err = section.UnmarshalBinary(sectionBody)
-			if err != nil {
-				return err
-			}
+		s.Status = UserStatus(sectionBody[0])
+	case SectionTypeTCPRelay:
+		section := sectionNodes{maxNodes: opts.MaxNodes}
+		err := section.UnmarshalBinary(sectionBody)
+		if err != nil {
+			return err
+		}
 
-			s.TCPRelays = section.Nodes
-		case sectionTypePathNode:
-			section := sectionNodes{}
-			err = section.UnmarshalBinary(sectionBody)
-			if err != nil {
-				return err
-			}
+		s.TCPRelays = section.Nodes
+	case SectionTypePathNode:
+		section := sectionNodes{maxNodes: opts.MaxNodes}
+		err := section.UnmarshalBinary(sectionBody)
+		if err != nil {
+			return err
+		}
 
-			s.PathNodes = section.Nodes
-		case sectionTypeDHT:
-			dhtReader := bytes.NewReader(sectionBody)
-			var dhtCookie uint32
+		s.PathNodes = section.Nodes
+	case SectionTypeConferences:
+		section := sectionConferences{}
+		err := section.UnmarshalBinary(sectionBody)
+		if err != nil {
+			return err
+		}
 
-			err = binary.Read(dhtReader, binary.LittleEndian, &dhtCookie)
+		s.Conferences = section.Conferences
+	case SectionTypeGroups:
+		section := sectionGroups{}
+		err := section.UnmarshalBinary(sectionBody)
+		if err != nil {
+			return err
+		}
+
+		s.Groups = section.Groups
+	case SectionTypeDHT:
+		return s.unmarshalDHT(sectionBody, opts)
+	default:
+		s.UnknownSections = append(s.UnknownSections, &Section{
+			Type: sectionType,
+			Body: sectionBody,
+		})
+	}
+
+	return nil
+}
+
+// unmarshalDHT decodes the body of the DHT section, which consists of
+// sub-sections of its own, into the state.
+func (s *State) unmarshalDHT(data []byte, opts DecodeOptions) error {
+	reader := bytes.NewReader(data)
+	fields := newFieldTracker(reader)
+
+	fields.begin("dht cookie")
+	var cookie uint32
+	err := util.ReadBinary(reader, binary.LittleEndian, &cookie)
+	if err != nil {
+		return fields.wrap(err)
+	} else if cookie != cookieDHTGlobal {
+		return fields.wrap(GlobalCookieError{actual: cookie, expected: cookieDHTGlobal})
+	}
+
+	// the unknown sub-sections that were found in front of the nodes
+	var pending []*Section
+
+	for reader.Len() > 0 {
+		sectionOffset := len(data) - reader.Len()
+		sectionType, sectionBody, err := readSection(reader, cookieDHTInner, opts.MaxSectionSize)
+		if err != nil {
+			return nestFieldError(err, sectionOffset, "")
+		}
+
+		switch sectionType {
+		case dhtSectionTypeNodes:
+			section := sectionNodes{maxNodes: opts.MaxNodes}
+			err = section.UnmarshalBinary(sectionBody)
 			if err != nil {
-				return err
-			} else if dhtCookie != cookieDHTGlobal {
-				return GlobalCookieError{actual: dhtCookie, expected: cookieDHTGlobal}
+				return nestFieldError(err, sectionOffset+sectionHeaderSize, "nodes: ")
 			}
 
-			for dhtReader.Len() > 0 {
-				dhtSectionType, dhtSectionBody, err := readSection(dhtReader, cookieDHTInner)
-				if err != nil {
-					return err
-				}
-
-				switch dhtSectionType {
-				case dhtSectionTypeNodes:
-					section := sectionNodes{}
-					err = section.UnmarshalBinary(dhtSectionBody)
-					if err != nil {
-						return err
-					}
-
-					s.Nodes = section.Nodes
-				default:
-					//unknown dht section type, ignore it
-				}
+			s.Nodes = section.Nodes
+			for _, unknown := range pending {
+				unknown.Before = dhtSectionTypeNodes
 			}
+			pending = nil
 		default:
-			//unknown section type, ignore it
+			unknown := &Section{
+				Type: sectionType,
+				Body: sectionBody,
+			}
+			s.UnknownDHTSections = append(s.UnknownDHTSections, unknown)
+			pending = append(pending, unknown)
 		}
 	}
+
+	return nil
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
-func (s *sectionNospamKeys) UnmarshalBinary(data []byte) error {
+func (s *NospamKeys) UnmarshalBinary(data []byte) (err error) {
 	reader := bytes.NewReader(data)
+	fields := newFieldTracker(reader)
+	defer func() { err = fields.wrap(err) }()
 
-	err := binary.Read(reader, binary.LittleEndian, &s.Nospam)
+	fields.begin("nospam")
+	err = util.ReadBinary(reader, binary.LittleEndian, &s.Nospam)
 	if err != nil {
 		return err
 	}
 
+	fields.begin("public key")
 	s.PublicKey = new([crypto.PublicKeySize]byte)
-	_, err = reader.Read(s.PublicKey[:])
+	err = util.ReadFull(reader, s.PublicKey[:])
 	if err != nil {
 		return err
 	}
 
+	fields.begin("secret key")
 	s.SecretKey = new([crypto.SecretKeySize]byte)
-	_, err = reader.Read(s.SecretKey[:])
-	return err
+	err = util.ReadFull(reader, s.SecretKey[:])
+	if err != nil {
+		return err
+	}
+
+	fields.begin("")
+	return util.AssertReaderEOF(reader)
 }
 
 // MarshalBinary implements the encoding.BinaryMarshaler interface.
-func (s *sectionNospamKeys) MarshalBinary() ([]byte, error) {
+func (s *NospamKeys) MarshalBinary() ([]byte, error) {
 	buff := new(bytes.Buffer)
 
 	err := binary.Write(buff, binary.LittleEndian, s.Nospam)
@@ -366,8 +509,10 @@ func (s *sectionNospamKeys) MarshalBinary() ([]byte, error) {
 }
 
 // UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
-func (s *sectionNodes) UnmarshalBinary(data []byte) error {
+func (s *sectionNodes) UnmarshalBinary(data []byte) (err error) {
 	reader := bytes.NewReader(data)
+	fields := newFieldTracker(reader)
+	defer func() { err = fields.wrap(err) }()
 
 	for reader.Len() > 0 {
 		var ipType byte
@@ -375,7 +520,9 @@ func (s *sectionNodes) UnmarshalBinary(data []byte) error {
 
 		node := new(dht.Node)
 
-		err := binary.Read(reader, binary.BigEndian, &ipType)
+		fields.prefix = fmt.Sprintf("node %d: ", len(s.Nodes))
+		fields.begin("address family")
+		err = util.ReadBinary(reader, binary.BigEndian, &ipType)
 		if err != nil {
 			return err
 		}
@@ -386,16 +533,21 @@ func (s *sectionNodes) UnmarshalBinary(data []byte) error {
 		case 10, 138: //ipv6
 			ipSize = net.IPv6len
 		default:
-			return fmt.Errorf("unknown address family: %d", ipType)
+			return fmt.Errorf("%w: unknown address family: %d", ErrMalformed, ipType)
 		}
 
+		fields.begin("node")
 		nodeBytes := make([]byte, 1+ipSize+2+crypto.PublicKeySize)
 		nodeBytes[0] = ipType
-		_, err = reader.Read(nodeBytes[1:])
+		err = util.ReadFull(reader, nodeBytes[1:])
 		if err != nil {
 			return err
 		}
 
+		if err = checkLimit("nodes", s.maxNodes, len(s.Nodes)+1); err != nil {
+			return err
+		}
+
 		err = node.UnmarshalBinary(nodeBytes)
 		if err != nil {
 			return err
@@ -446,41 +598,84 @@ func writeSection(writer io.Writer, sectionType uint16, cookie uint16, body []by
 	return err
 }
 
-func readSection(reader io.Reader, expectedCookie uint16) (uint16, []byte, error) {
+// readSection reads a section from the given reader. Sections larger than
+// maxSize are rejected with a LimitError, unless maxSize is negative.
+//
+// If an error occurs after the type of the section was read, that type is
+// returned along with the error, which is annotated with the field that was
+// being read relative to the start of the section.
+func readSection(r io.Reader, expectedCookie uint16, maxSize int) (sectionType uint16, body []byte, err error) {
+	reader := &countingReader{r: r}
+	fields := &fieldTracker{pos: reader.pos, friend: -1}
+	defer func() { err = fields.wrap(err) }()
+
+	fields.begin("section length")
 	var length uint32
-	err := binary.Read(reader, binary.LittleEndian, &length)
+	err = util.ReadBinary(reader, binary.LittleEndian, &length)
 	if err != nil {
 		return 0, nil, err
 	}
 
-	var sectionType uint16
-	err = binary.Read(reader, binary.LittleEndian, &sectionType)
+	fields.begin("section type")
+	err = util.ReadBinary(reader, binary.LittleEndian, &sectionType)
 	if err != nil {
 		return 0, nil, err
-	} else if sectionType == sectionTypeEnd {
-		//this means we've reached the end of the state file
-		return sectionTypeEnd, nil, nil
 	}
 
+	fields.begin("section cookie")
 	var cookie uint16
-	err = binary.Read(reader, binary.LittleEndian, &cookie)
+	err = util.ReadBinary(reader, binary.LittleEndian, &cookie)
 	if err != nil {
-		return 0, nil, err
+		return sectionType, nil, err
 	} else if cookie != expectedCookie {
-		return 0, nil, InnerCookieError{actual: cookie, expected: expectedCookie}
+		return sectionType, nil, InnerCookieError{actual: cookie, expected: expectedCookie}
+	}
+
+	if sectionType == SectionTypeEnd {
+		//this means we've reached the end of the state file
+		return SectionTypeEnd, nil, nil
 	}
 
-	sectionBody := make([]byte, length)
-	_, err = reader.Read(sectionBody)
+	fields.begin("section body")
+	if err = checkLimit("section size", maxSize, int(length)); err != nil {
+		return sectionType, nil, err
+	}
+
+	//don't allocate the entire length up front, it comes from untrusted input
+	sectionBody, err := io.ReadAll(io.LimitReader(reader, int64(length)))
 	if err != nil {
-		return 0, nil, err
+		return sectionType, nil, err
+	} else if len(sectionBody) < int(length) {
+		return sectionType, nil, fmt.Errorf("%w: section length %d exceeds the %d remaining bytes", ErrTruncated, length, len(sectionBody))
 	}
 
 	return sectionType, sectionBody, nil
 }
 
+// countingReader counts the amount of bytes that are read from the underlying
+// reader.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+// pos returns the amount of bytes that were read so far.
+func (r *countingReader) pos() int {
+	return r.n
+}
+
 func writeStringWithSize(writer io.Writer, toWrite string, size uint16, paddingSize int) error {
 	bytesToWrite := []byte(toWrite)
+	if len(bytesToWrite) > int(size) {
+		return fmt.Errorf("string too long: %d > %d", len(bytesToWrite), size)
+	}
+
 	data := make([]byte, size)
 	copy(data, bytesToWrite)
 
@@ -502,27 +697,19 @@ func writeStringWithSize(writer io.Writer, toWrite string, size uint16, paddingS
 	return err
 }
 
-func readStringWithSize(reader io.ReadSeeker, size uint16, paddingSize int) (string, error) {
-	str := make([]byte, size)
-	_, err := reader.Read(str)
+func readStringWithSize(reader io.Reader, size uint16, paddingSize int) (string, error) {
+	str := make([]byte, int(size)+paddingSize)
+	err := util.ReadFull(reader, str)
 	if err != nil {
 		return "", err
 	}
 
-	//skip padding
-	if paddingSize > 0 {
-		_, err = reader.Seek(int64(paddingSize), 1)
-		if err != nil {
-			return "", err
-		}
-	}
-
 	var strSize uint16
-	err = binary.Read(reader, binary.BigEndian, &strSize)
+	err = util.ReadBinary(reader, binary.BigEndian, &strSize)
 	if err != nil {
 		return "", err
-	} else if int(strSize) > len(str) {
-		return "", fmt.Errorf("invalid string size: %d > %d", strSize, len(str))
+	} else if strSize > size {
+		return "", fmt.Errorf("%w: invalid string size: %d > %d", ErrMalformed, strSize, size)
 	}
 
 	return string(str[:strSize]), nil
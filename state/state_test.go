@@ -0,0 +1,524 @@
+package state
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/dht"
+)
+
+func newTestState(t *testing.T) *State {
+	publicKey, secretKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &State{
+		PublicKey: publicKey,
+		SecretKey: secretKey,
+		Nospam:    0xdeadbeef,
+		Name:      "tox4go",
+	}
+}
+
+func TestStateUnknownSections(t *testing.T) {
+	s := newTestState(t)
+	s.UnknownSections = []*Section{
+		{Type: 101, Body: []byte{0x90}},
+		{Type: 100, Body: []byte{}},
+		{Type: 0x1337, Body: []byte{1, 2, 3, 4}},
+	}
+	s.UnknownDHTSections = []*Section{
+		{Type: 0x42, Body: []byte{5, 6, 7}},
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res State
+	if err = res.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.UnknownSections, s.UnknownSections) {
+		t.Fatal("unknown sections do not match")
+	}
+	if !reflect.DeepEqual(res.UnknownDHTSections, s.UnknownDHTSections) {
+		t.Fatal("unknown dht sections do not match")
+	}
+
+	data2, err := res.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Fatal("round trip does not match")
+	}
+}
+
+func TestStateUnknownSectionPosition(t *testing.T) {
+	s := newTestState(t)
+	s.Friends = []*Friend{
+		{
+			Status:    FriendStatusConfirmed,
+			PublicKey: &[crypto.PublicKeySize]byte{1},
+		},
+	}
+	s.Nodes = []*dht.Node{
+		{
+			Type:      dht.NodeTypeUDPIP4,
+			PublicKey: &dht.PublicKey{2},
+			IP:        net.IPv4(127, 0, 0, 1).To4(),
+			Port:      33445,
+		},
+	}
+
+	plain, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// insert an unknown section in front of the name section and an unknown
+	// sub-section in front of the nodes in the dht section
+	buff := new(bytes.Buffer)
+	enc := NewEncoder(buff)
+	dec := NewDecoder(bytes.NewReader(plain), DecodeOptions{})
+	for {
+		section, err := dec.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		switch section.Type {
+		case SectionTypeName:
+			if err = enc.WriteSection(100, []byte{1, 2, 3}); err != nil {
+				t.Fatal(err)
+			}
+		case SectionTypeDHT:
+			body := bytes.NewBuffer(section.Body[:4:4])
+			if err = writeSection(body, 0x42, cookieDHTInner, []byte{4, 5}); err != nil {
+				t.Fatal(err)
+			}
+			body.Write(section.Body[4:])
+			section.Body = body.Bytes()
+		}
+		if err = enc.WriteSection(section.Type, section.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buff.Bytes()
+
+	var res State
+	if err = res.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.UnknownSections) != 1 || res.UnknownSections[0].Before != SectionTypeName {
+		t.Fatalf("bad unknown sections: %+v", res.UnknownSections)
+	}
+	if len(res.UnknownDHTSections) != 1 || res.UnknownDHTSections[0].Before != dhtSectionTypeNodes {
+		t.Fatalf("bad unknown dht sections: %+v", res.UnknownDHTSections)
+	}
+
+	data2, err := res.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, data2) {
+		t.Fatal("round trip does not match")
+	}
+}
+
+func TestStateConferences(t *testing.T) {
+	s := newTestState(t)
+	s.Conferences = []*Conference{
+		{
+			Type:               ConferenceTypeText,
+			ID:                 &[ConferenceIDSize]byte{1, 2, 3},
+			MessageNumber:      1337,
+			LossyMessageNumber: 42,
+			PeerNumber:         1,
+			Title:              "tox4go",
+			Peers: []*ConferencePeer{
+				{
+					PublicKey:     &[crypto.PublicKeySize]byte{4},
+					TempPublicKey: &[crypto.PublicKeySize]byte{5},
+					PeerNumber:    0,
+					LastActive:    1700000000,
+					Nick:          "alice",
+				},
+				{
+					PublicKey:     s.PublicKey,
+					TempPublicKey: &[crypto.PublicKeySize]byte{6},
+					PeerNumber:    1,
+				},
+			},
+		},
+		{
+			Type: ConferenceTypeAV,
+			ID:   &[ConferenceIDSize]byte{7},
+		},
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res State
+	if err = res.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Conferences, s.Conferences) {
+		t.Fatal("conferences do not match")
+	}
+	if len(res.UnknownSections) != 0 {
+		t.Fatalf("bad unknown section count: expected: %d, actual: %d", 0, len(res.UnknownSections))
+	}
+}
+
+func TestStateGroups(t *testing.T) {
+	s := newTestState(t)
+	s.Groups = []*Group{
+		{
+			SharedState: GroupSharedState{
+				FounderPublicKey: &[GroupExtPublicKeySize]byte{1},
+				MaxPeers:         100,
+				Name:             "tox4go",
+				PrivacyState:     GroupPrivacyStatePrivate,
+				Password:         "hunter2",
+				ModListHash:      &[GroupModListHashSize]byte{2},
+				Version:          1337,
+				TopicLock:        GroupTopicLockDisabled,
+				VoiceState:       GroupVoiceStateModerator,
+			},
+			SharedStateSignature: &[GroupSignatureSize]byte{3},
+			Topic: GroupTopic{
+				Version:   3,
+				Checksum:  0xbeef,
+				Topic:     "Tox in Go",
+				PublicKey: &[GroupSigPublicKeySize]byte{4},
+				Signature: &[GroupSignatureSize]byte{5},
+			},
+			Moderators: []*[GroupSigPublicKeySize]byte{
+				{6},
+				{7},
+			},
+			ChatPublicKey: &[GroupExtPublicKeySize]byte{8},
+			ChatSecretKey: &[GroupExtSecretKeySize]byte{9},
+			SelfPublicKey: &[GroupExtPublicKeySize]byte{10},
+			SelfSecretKey: &[GroupExtSecretKeySize]byte{11},
+			Self: GroupSelf{
+				Nick:   "alice",
+				Role:   GroupRoleFounder,
+				Status: UserStatusBusy,
+			},
+			SavedPeers: GroupSavedPeers{
+				Count: 1,
+				Data:  []byte{12, 13, 14},
+			},
+		},
+		{
+			Disconnected:         true,
+			SharedState:          GroupSharedState{FounderPublicKey: new([GroupExtPublicKeySize]byte), ModListHash: new([GroupModListHashSize]byte)},
+			SharedStateSignature: new([GroupSignatureSize]byte),
+			Topic:                GroupTopic{PublicKey: new([GroupSigPublicKeySize]byte), Signature: new([GroupSignatureSize]byte)},
+			ChatPublicKey:        new([GroupExtPublicKeySize]byte),
+			ChatSecretKey:        new([GroupExtSecretKeySize]byte),
+			SelfPublicKey:        new([GroupExtPublicKeySize]byte),
+			SelfSecretKey:        new([GroupExtSecretKeySize]byte),
+		},
+	}
+
+	section := sectionGroups{Groups: s.Groups}
+	body, err := section.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// array of 2 groups, array of 7 fields, array of 8 state values,
+	// connected, name length, private, max peers
+	header := []byte{0x92, 0x97, 0x98, 0xc2, 0x06, 0x01, 0x64}
+	if !bytes.HasPrefix(body, header) {
+		t.Fatalf("bad section header: expected: %x, actual: %x", header, body[:len(header)])
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res State
+	if err = res.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.Groups, s.Groups) {
+		t.Fatal("groups do not match")
+	}
+	if len(res.UnknownSections) != 0 {
+		t.Fatalf("bad unknown section count: expected: %d, actual: %d", 0, len(res.UnknownSections))
+	}
+
+	// empty saved peers are written as nil, like c-toxcore does
+	s.Groups[1].SavedPeers.Data = []byte{}
+	body, err = section.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// array of 2 saved peer fields, count, nil
+	footer := []byte{0x92, 0x00, 0xc0}
+	if !bytes.HasSuffix(body, footer) {
+		t.Fatalf("bad saved peers: expected: %x, actual: %x", footer, body[len(body)-len(footer):])
+	}
+
+	var groups sectionGroups
+	if err = groups.UnmarshalBinary(body); err != nil {
+		t.Fatal(err)
+	}
+	if groups.Groups[1].SavedPeers.Data != nil {
+		t.Fatalf("bad saved peers: expected: nil, actual: %x", groups.Groups[1].SavedPeers.Data)
+	}
+	if data, err = groups.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, body) {
+		t.Fatalf("bad groups section: expected: %x, actual: %x", body, data)
+	}
+}
+
+func TestStateTruncated(t *testing.T) {
+	s := newTestState(t)
+	s.Friends = []*Friend{
+		{
+			Status:    FriendStatusConfirmed,
+			PublicKey: &[crypto.PublicKeySize]byte{1},
+			Name:      "alice",
+		},
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if err = new(State).UnmarshalBinary(data[:i]); !errors.Is(err, ErrTruncated) {
+			t.Fatalf("state truncated to %d bytes: bad error: %v", i, err)
+		}
+	}
+}
+
+func TestStateLimits(t *testing.T) {
+	s := newTestState(t)
+	for i := 0; i < 3; i++ {
+		s.Friends = append(s.Friends, &Friend{
+			Status:    FriendStatusConfirmed,
+			PublicKey: &[crypto.PublicKeySize]byte{byte(i)},
+		})
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var limitErr LimitError
+	err = new(State).UnmarshalBinaryWithOptions(data, DecodeOptions{MaxFriends: 2})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "friends" {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	err = new(State).UnmarshalBinaryWithOptions(data, DecodeOptions{MaxSize: len(data) - 1})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "size" {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	err = new(State).UnmarshalBinaryWithOptions(data, DecodeOptions{MaxSectionSize: 100})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "section size" {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	if err = new(State).UnmarshalBinaryWithOptions(data, DecodeOptions{MaxFriends: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A section that claims to be 4 GiB must not be allocated
+	malicious := []byte{
+		0, 0, 0, 0, 0x1f, 0x1b, 0xed, 0x15,
+		0xff, 0xff, 0xff, 0xff, 0x01, 0x00, 0xce, 0x01,
+	}
+	err = new(State).UnmarshalBinaryWithOptions(malicious, DecodeOptions{MaxSectionSize: -1})
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrTruncated, err)
+	}
+	if err = new(State).UnmarshalBinary(malicious); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrLimitExceeded, err)
+	}
+}
+
+func TestStateParseError(t *testing.T) {
+	s := newTestState(t)
+	for i := 0; i < 2; i++ {
+		s.Friends = append(s.Friends, &Friend{
+			Status:    FriendStatusConfirmed,
+			PublicKey: &[crypto.PublicKeySize]byte{byte(i)},
+		})
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header, nospam and keys section and the header of the friends section
+	const friendsOffset = 8 + 8 + 4 + 2*crypto.PublicKeySize + 8
+	// status, public key, request message and its size
+	const nameOffset = 1 + crypto.PublicKeySize + maxRequestMessageSize + 1 + 2
+	// name, status message, user status, padding, nospam and last seen
+	const friendSize = nameOffset + maxNameSize + 2 + maxStatusMessageSize + 1 + 2 + 1 + 3 + 4 + 8
+
+	// corrupt the size of the name of the second friend
+	offset := friendsOffset + friendSize + nameOffset
+	binary.BigEndian.PutUint16(data[offset+maxNameSize:], maxNameSize+1)
+
+	var parseErr ParseError
+	err = new(State).UnmarshalBinary(data)
+	if !errors.As(err, &parseErr) || !errors.Is(err, ErrMalformed) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if parseErr.Offset != offset {
+		t.Fatalf("bad offset: expected: %d, actual: %d", offset, parseErr.Offset)
+	}
+	if parseErr.SectionType != SectionTypeFriends || parseErr.SectionName != "friends" {
+		t.Fatalf("bad section: %d (%s)", parseErr.SectionType, parseErr.SectionName)
+	}
+	if parseErr.Friend != 1 {
+		t.Fatalf("bad friend: expected: %d, actual: %d", 1, parseErr.Friend)
+	}
+	if parseErr.Field != "name" {
+		t.Fatalf("bad field: %s", parseErr.Field)
+	}
+}
+
+func TestStateValidate(t *testing.T) {
+	s := newTestState(t)
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Name = "\xff"
+	// too long and invalid UTF-8, which are two problems
+	s.StatusMessage = strings.Repeat("\xff", maxStatusMessageSize+1)
+	s.Friends = []*Friend{
+		{
+			Status:    FriendStatusOnline + 1,
+			PublicKey: s.PublicKey,
+			Name:      strings.Repeat("a", maxNameSize+1),
+		},
+		{
+			PublicKey: s.PublicKey,
+		},
+	}
+	s.Nodes = []*dht.Node{
+		{
+			Type:      dht.NodeTypeUDPIP4,
+			PublicKey: &dht.PublicKey{},
+			IP:        net.ParseIP("127.0.0.1"),
+			Port:      33445,
+		},
+	}
+
+	var validationErr ValidationError
+	if err := s.Validate(); !errors.As(err, &validationErr) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if n := len(validationErr.Problems); n != 9 {
+		t.Fatalf("bad amount of problems: expected: %d, actual: %d: %v", 9, n, validationErr)
+	}
+	if !errors.Is(validationErr.Problems[1], ErrStringTooLong) || !errors.Is(validationErr.Problems[2], ErrInvalidUTF8) {
+		t.Fatalf("bad status message problems: %v, %v", validationErr.Problems[1], validationErr.Problems[2])
+	}
+
+	if _, err := s.MarshalBinary(); err == nil {
+		t.Fatal("oversized friend name was written")
+	}
+}
+
+func TestStateEdit(t *testing.T) {
+	s := newTestState(t)
+	if err := s.RegenerateNospam(); err != nil {
+		t.Fatal(err)
+	}
+
+	id := s.ToxID()
+	if *id.PublicKey() != *s.PublicKey || id.Nospam() != s.Nospam {
+		t.Fatalf("bad tox id: %x", id)
+	}
+	// the checksum is the XOR of the rest of the ID in chunks of two bytes, so
+	// the XOR of the entire ID must be zero
+	var sum [2]byte
+	for i, b := range id {
+		sum[i%2] ^= b
+	}
+	if sum != [2]byte{} {
+		t.Fatalf("bad tox id checksum: %x", id)
+	}
+
+	if _, err := s.AddFriend(id, "hi"); !errors.Is(err, ErrOwnPublicKey) {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	friendState := newTestState(t)
+	friendID := friendState.ToxID()
+	badID := friendID
+	badID[ToxIDSize-1] ^= 1
+	if _, err := s.AddFriend(badID, "hi"); !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if _, err := s.AddFriend(friendID, strings.Repeat("a", maxRequestMessageSize+1)); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	friend, err := s.AddFriend(friendID, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *friend.PublicKey != *friendState.PublicKey || friend.Nospam != friendState.Nospam || friend.Status != FriendStatusAdded {
+		t.Fatalf("bad friend: %+v", friend)
+	}
+	if _, err = s.AddFriend(friendID, "hi"); !errors.Is(err, ErrFriendExists) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if err = s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.RemoveFriend(friendState.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Friends); n != 0 {
+		t.Fatalf("bad amount of friends: expected: %d, actual: %d", 0, n)
+	}
+	if err = s.RemoveFriend(friendState.PublicKey); !errors.Is(err, ErrFriendNotFound) {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	if err = s.SetName(strings.Repeat("a", maxNameSize+1)); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if err = s.SetStatusMessage("\xff"); !errors.Is(err, ErrInvalidUTF8) {
+		t.Fatalf("bad error: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alexbakker/tox4go/crypto"
+)
+
+const (
+	// ToxIDSize is the size of a Tox ID in bytes.
+	ToxIDSize = crypto.PublicKeySize + 4 + 2
+
+	// toxURIScheme is the scheme of tox: URIs, which contain a Tox ID.
+	toxURIScheme = "tox:"
+)
+
+var (
+	// ErrInvalidToxID is returned when parsing a Tox ID that is not made up
+	// of exactly ToxIDSize hex encoded bytes.
+	ErrInvalidToxID = errors.New("invalid Tox ID")
+
+	// ErrBadChecksum is wrapped by ChecksumError.
+	ErrBadChecksum = errors.New("bad Tox ID checksum")
+)
+
+// ChecksumError is returned when using a Tox ID with a checksum that doesn't
+// match its contents. This usually means that the Tox ID was mistyped.
+type ChecksumError struct {
+	// Expected is the checksum calculated from the contents of the Tox ID.
+	Expected [2]byte
+	// Actual is the checksum found in the Tox ID.
+	Actual [2]byte
+}
+
+func (e ChecksumError) Error() string {
+	return fmt.Sprintf("bad Tox ID checksum: %X (should be %X)", e.Actual, e.Expected)
+}
+
+// Unwrap returns ErrBadChecksum, so that checksum errors can be detected with
+// errors.Is(err, ErrBadChecksum).
+func (e ChecksumError) Unwrap() error {
+	return ErrBadChecksum
+}
+
+// ToxID is the address that others use to send a friend request to a Tox user.
+// It consists of the public key of the user, the nospam value and a checksum
+// of both.
+type ToxID [ToxIDSize]byte
+
+// NewToxID creates a new Tox ID for the given public key and nospam value.
+func NewToxID(publicKey *[crypto.PublicKeySize]byte, nospam uint32) ToxID {
+	var id ToxID
+	copy(id[:], publicKey[:])
+	// c-toxcore copies the nospam value into the ID as is, and stores it in
+	// the state file in little-endian byte order, so do the same here
+	binary.LittleEndian.PutUint32(id[crypto.PublicKeySize:], nospam)
+	checksum := id.computeChecksum()
+	copy(id[crypto.PublicKeySize+4:], checksum[:])
+	return id
+}
+
+// ParseToxID parses a Tox ID from its hex representation. The hex
+// representation may be prefixed with "tox:" or "tox://", as found in tox:
+// URIs. The checksum of the Tox ID is verified.
+func ParseToxID(s string) (ToxID, error) {
+	var id ToxID
+
+	if len(s) >= len(toxURIScheme) && strings.EqualFold(s[:len(toxURIScheme)], toxURIScheme) {
+		s = strings.TrimPrefix(s[len(toxURIScheme):], "//")
+	}
+
+	if len(s) != hex.EncodedLen(ToxIDSize) {
+		return id, fmt.Errorf("%w: bad length: %d (should be %d)", ErrInvalidToxID, len(s), hex.EncodedLen(ToxIDSize))
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, fmt.Errorf("%w: %s", ErrInvalidToxID, err)
+	}
+
+	if err := id.Verify(); err != nil {
+		return id, err
+	}
+
+	return id, nil
+}
+
+// Verify checks whether the checksum in the Tox ID matches its contents. If it
+// doesn't, a ChecksumError is returned.
+func (id ToxID) Verify() error {
+	if expected := id.computeChecksum(); expected != id.Checksum() {
+		return ChecksumError{Expected: expected, Actual: id.Checksum()}
+	}
+
+	return nil
+}
+
+// String returns the hex representation of the Tox ID in upper case, as
+// displayed by Tox clients.
+func (id ToxID) String() string {
+	return strings.ToUpper(hex.EncodeToString(id[:]))
+}
+
+// URI returns the Tox ID as a tox: URI.
+func (id ToxID) URI() string {
+	return toxURIScheme + id.String()
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id ToxID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface. It accepts
+// everything that ParseToxID accepts.
+func (id *ToxID) UnmarshalText(data []byte) error {
+	parsed, err := ParseToxID(string(data))
+	if err != nil {
+		return err
+	}
+
+	*id = parsed
+	return nil
+}
+
+// PublicKey returns the public key in the Tox ID.
+func (id ToxID) PublicKey() *[crypto.PublicKeySize]byte {
+	publicKey := new([crypto.PublicKeySize]byte)
+	copy(publicKey[:], id[:])
+	return publicKey
+}
+
+// Nospam returns the nospam value in the Tox ID.
+func (id ToxID) Nospam() uint32 {
+	return binary.LittleEndian.Uint32(id[crypto.PublicKeySize:])
+}
+
+// Checksum returns the checksum in the Tox ID.
+func (id ToxID) Checksum() [2]byte {
+	var checksum [2]byte
+	copy(checksum[:], id[crypto.PublicKeySize+4:])
+	return checksum
+}
+
+// computeChecksum calculates the checksum of the public key and nospam value
+// in the Tox ID by XOR'ing them together in chunks of two bytes.
+func (id ToxID) computeChecksum() [2]byte {
+	var checksum [2]byte
+	for i, b := range id[:crypto.PublicKeySize+4] {
+		checksum[i%2] ^= b
+	}
+	return checksum
+}
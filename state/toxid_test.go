@@ -0,0 +1,71 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestToxID(t *testing.T) {
+	s := newTestState(t)
+	id := s.ToxID()
+
+	for _, str := range []string{
+		id.String(),
+		strings.ToLower(id.String()),
+		id.URI(),
+		"TOX:" + id.String(),
+		"tox://" + id.String(),
+	} {
+		parsed, err := ParseToxID(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != id {
+			t.Fatalf("bad tox id: expected: %s, actual: %s", id, parsed)
+		}
+	}
+
+	data, err := json.Marshal(map[string]ToxID{"id": id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]ToxID
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["id"] != id {
+		t.Fatalf("bad tox id: expected: %s, actual: %s", id, decoded["id"])
+	}
+
+	badID := id
+	badID[ToxIDSize-2] ^= 0xff
+	var checksumErr ChecksumError
+	_, err = ParseToxID(badID.String())
+	if !errors.As(err, &checksumErr) || !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("bad error: %v", err)
+	}
+	if checksumErr.Expected != id.Checksum() || checksumErr.Actual != badID.Checksum() {
+		t.Fatalf("bad checksum error: %v", checksumErr)
+	}
+
+	for _, str := range []string{
+		id.String()[2:],
+		"tox:" + id.String() + "00",
+		"ZZ" + id.String()[2:],
+	} {
+		if _, err = ParseToxID(str); !errors.Is(err, ErrInvalidToxID) {
+			t.Fatalf("bad error for %s: %v", str, err)
+		}
+	}
+
+	friendState := newTestState(t)
+	friend, err := s.AddFriend(friendState.ToxID(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if friend.ToxID() != friendState.ToxID() {
+		t.Fatalf("bad friend tox id: expected: %s, actual: %s", friendState.ToxID(), friend.ToxID())
+	}
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alexbakker/tox4go/crypto"
+	"github.com/alexbakker/tox4go/dht"
+)
+
+// ValidationError is returned by Validate. It contains all of the problems
+// that were found in the state.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Error())
+	}
+
+	return fmt.Sprintf("invalid state: %s", strings.Join(problems, "; "))
+}
+
+// Unwrap returns the problems that were found, so that they can be inspected
+// with errors.Is and errors.As.
+func (e ValidationError) Unwrap() []error {
+	return e.Problems
+}
+
+// validator collects the problems found by Validate.
+type validator struct {
+	problems []error
+}
+
+func (v *validator) addf(format string, a ...any) {
+	v.problems = append(v.problems, fmt.Errorf(format, a...))
+}
+
+// Validate checks the state for problems that would result in a state file
+// that other Tox clients reject or misinterpret, or that MarshalBinary
+// refuses to write. If any problems are found, a ValidationError that contains
+// all of them is returned.
+func (s *State) Validate() error {
+	var v validator
+
+	if s.PublicKey == nil {
+		v.addf("missing public key")
+	}
+	if s.SecretKey == nil {
+		v.addf("missing secret key")
+	}
+	if s.PublicKey != nil && s.SecretKey != nil && *crypto.DerivePublicKey(s.SecretKey) != *s.PublicKey {
+		v.addf("public key doesn't belong to the secret key")
+	}
+
+	v.validateString("name", s.Name, maxNameSize)
+	v.validateString("status message", s.StatusMessage, maxStatusMessageSize)
+	v.validateUserStatus("status", s.Status)
+
+	seen := make(map[[crypto.PublicKeySize]byte]int, len(s.Friends))
+	for i, friend := range s.Friends {
+		prefix := fmt.Sprintf("friend %d: ", i)
+
+		if friend.PublicKey == nil {
+			v.addf("%smissing public key", prefix)
+		} else {
+			if s.PublicKey != nil && *friend.PublicKey == *s.PublicKey {
+				v.addf("%spublic key is our own", prefix)
+			}
+			if j, ok := seen[*friend.PublicKey]; ok {
+				v.addf("%spublic key is the same as that of friend %d", prefix, j)
+			} else {
+				seen[*friend.PublicKey] = i
+			}
+		}
+
+		if friend.Status > FriendStatusOnline {
+			v.addf("%sbad friend status: %d", prefix, friend.Status)
+		}
+		v.validateUserStatus(prefix+"user status", friend.UserStatus)
+		v.validateString(prefix+"request message", friend.RequestMessage, maxRequestMessageSize)
+		v.validateString(prefix+"name", friend.Name, maxNameSize)
+		v.validateString(prefix+"status message", friend.StatusMessage, maxStatusMessageSize)
+	}
+
+	v.validateNodes("dht node", s.Nodes)
+	v.validateNodes("tcp relay", s.TCPRelays)
+	v.validateNodes("path node", s.PathNodes)
+
+	if len(v.problems) > 0 {
+		return ValidationError{Problems: v.problems}
+	}
+
+	return nil
+}
+
+// validateString checks the size and the encoding of the given string
+// separately, unlike checkString, so that both problems are reported.
+func (v *validator) validateString(name string, str string, maxSize int) {
+	if len(str) > maxSize {
+		v.addf("%w: %s: %d > %d", ErrStringTooLong, name, len(str), maxSize)
+	}
+	if !utf8.ValidString(str) {
+		v.addf("%w: %s", ErrInvalidUTF8, name)
+	}
+}
+
+func (v *validator) validateUserStatus(name string, status UserStatus) {
+	if status > UserStatusBusy {
+		v.addf("bad %s: %d", name, status)
+	}
+}
+
+func (v *validator) validateNodes(name string, nodes []*dht.Node) {
+	for i, node := range nodes {
+		prefix := fmt.Sprintf("%s %d: ", name, i)
+
+		if node.PublicKey == nil {
+			v.addf("%smissing public key", prefix)
+		}
+		if node.Port < 0 || node.Port > 0xffff {
+			v.addf("%sbad port: %d", prefix, node.Port)
+		}
+
+		switch node.Type {
+		case dht.NodeTypeUDPIP4, dht.NodeTypeTCPIP4:
+			if len(node.IP) != net.IPv4len {
+				v.addf("%sbad address size for node type %s: %d", prefix, node.Type.Net(), len(node.IP))
+			}
+		case dht.NodeTypeUDPIP6, dht.NodeTypeTCPIP6:
+			if len(node.IP) != net.IPv6len {
+				v.addf("%sbad address size for node type %s: %d", prefix, node.Type.Net(), len(node.IP))
+			}
+		default:
+			v.addf("%sbad node type: %d", prefix, node.Type)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+// Mux is a packet multiplexer. It routes incoming packets to the handler that
+// is registered for their packet type, which is the first byte of a packet.
+// Packets for which no handler is registered are passed to the fallback
+// handler, if any. It is safe for concurrent use.
+//
+// The HandlePacket method of a Mux is meant to be passed to a transport as its
+// packet handler:
+//
+//	mux := transport.NewMux()
+//	mux.Handle(byte(bootstrap.PacketTypeBootstrapInfo), handleBootstrapInfo)
+//	tr, err := transport.NewUDPTransport("udp", ":33445", mux.HandlePacket, transport.UDPTransportOptions{})
+type Mux struct {
+	mu       sync.RWMutex
+	handlers [256]PacketHandler
+	fallback PacketHandler
+}
+
+// NewMux creates a new packet multiplexer without any handlers.
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+// Handle registers the handler for packets of the given type. If a handler
+// was already registered for the given packet type, it is replaced. Passing a
+// nil handler removes the registration.
+func (m *Mux) Handle(packetType byte, handler PacketHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[packetType] = handler
+}
+
+// HandleFallback registers the handler for packets of a type that no other
+// handler is registered for. Passing a nil handler drops these packets.
+func (m *Mux) HandleFallback(handler PacketHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fallback = handler
+}
+
+// HandlePacket implements PacketHandler. Empty packets are dropped.
+func (m *Mux) HandlePacket(data []byte, addr net.Addr) {
+	if len(data) < 1 {
+		return
+	}
+
+	m.mu.RLock()
+	handler := m.handlers[data[0]]
+	if handler == nil {
+		handler = m.fallback
+	}
+	m.mu.RUnlock()
+
+	if handler != nil {
+		handler(data, addr)
+	}
+}
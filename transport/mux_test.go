@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	var handled []string
+	newHandler := func(name string) PacketHandler {
+		return func(data []byte, addr net.Addr) {
+			handled = append(handled, name)
+		}
+	}
+
+	mux := NewMux()
+	mux.Handle(0x00, newHandler("ping"))
+	mux.Handle(0xF0, newHandler("bootstrap"))
+
+	mux.HandlePacket([]byte{0x00}, nil)
+	mux.HandlePacket([]byte{0x01}, nil)
+	mux.HandlePacket([]byte{}, nil)
+	mux.HandleFallback(newHandler("fallback"))
+	mux.HandlePacket([]byte{0x01}, nil)
+	mux.HandlePacket([]byte{0xF0, 0x01}, nil)
+	mux.Handle(0x00, nil)
+	mux.HandlePacket([]byte{0x00}, nil)
+
+	expected := []string{"ping", "fallback", "bootstrap", "fallback"}
+	if len(handled) != len(expected) {
+		t.Fatalf("bad amount of handled packets: expected: %d, actual: %d", len(expected), len(handled))
+	}
+	for i := range expected {
+		if handled[i] != expected[i] {
+			t.Fatalf("bad handler for packet %d: expected: %s, actual: %s", i, expected[i], handled[i])
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package simnet
+
+import (
+	"net/netip"
+
+	"github.com/alexbakker/tox4go/transport"
+)
+
+// NATType determines how a NAT maps private addresses to public ones and which
+// inbound packets it lets through.
+type NATType int
+
+const (
+	// NATFullCone maps every private address to a single public address and
+	// lets through inbound packets from any address.
+	NATFullCone NATType = iota + 1
+	// NATRestrictedCone maps every private address to a single public address
+	// and only lets through inbound packets from IP addresses that the private
+	// address has sent packets to.
+	NATRestrictedCone
+	// NATPortRestrictedCone maps every private address to a single public
+	// address and only lets through inbound packets from IP address and port
+	// combinations that the private address has sent packets to.
+	NATPortRestrictedCone
+	// NATSymmetric maps every combination of private address and destination
+	// to a different public address, and only lets through inbound packets from
+	// that destination.
+	NATSymmetric
+)
+
+func (t NATType) String() string {
+	switch t {
+	case NATFullCone:
+		return "full cone"
+	case NATRestrictedCone:
+		return "restricted cone"
+	case NATPortRestrictedCone:
+		return "port restricted cone"
+	case NATSymmetric:
+		return "symmetric"
+	default:
+		return "unknown"
+	}
+}
+
+// NAT is a virtual network address translator with a single public IP address.
+// Endpoints behind a NAT are given a private address and can only be reached
+// from outside the NAT through a mapping that was created by sending a packet.
+type NAT struct {
+	network   *Network
+	typ       NATType
+	publicIP  netip.Addr
+	nextIP    netip.Addr
+	nextPort  uint16
+	endpoints map[netip.AddrPort]*Endpoint
+	mappings  map[natKey]*natMapping
+	reverse   map[netip.AddrPort]*natMapping
+}
+
+type natKey struct {
+	private netip.AddrPort
+	dest    netip.AddrPort
+}
+
+type natMapping struct {
+	private netip.AddrPort
+	public  netip.AddrPort
+	allowed map[netip.AddrPort]struct{}
+}
+
+// NewNAT creates a new NAT of the given type with a public IP address of its
+// own.
+func (n *Network) NewNAT(typ NATType) *NAT {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nat := &NAT{
+		network:   n,
+		typ:       typ,
+		publicIP:  n.allocIP(),
+		nextIP:    netip.AddrFrom4([4]byte{192, 168, 0, 1}),
+		nextPort:  DefaultPort,
+		endpoints: make(map[netip.AddrPort]*Endpoint),
+		mappings:  make(map[natKey]*natMapping),
+		reverse:   make(map[netip.AddrPort]*natMapping),
+	}
+	n.nats[nat.publicIP] = nat
+	return nat
+}
+
+// Type returns the type of the NAT.
+func (nat *NAT) Type() NATType {
+	return nat.typ
+}
+
+// PublicIP returns the public IP address of the NAT.
+func (nat *NAT) PublicIP() netip.Addr {
+	return nat.publicIP
+}
+
+// NewEndpoint creates a new endpoint with a private address behind the NAT.
+// Packets that arrive at the endpoint are passed to the given handler.
+func (nat *NAT) NewEndpoint(handler transport.PacketHandler) *Endpoint {
+	nat.network.mu.Lock()
+	defer nat.network.mu.Unlock()
+
+	nat.nextIP = nat.nextIP.Next()
+	e := newEndpoint(nat.network, nat, netip.AddrPortFrom(nat.nextIP, DefaultPort), handler)
+	nat.endpoints[e.addr] = e
+	return e
+}
+
+// outbound returns the public address for a packet from the given private
+// address to the given destination, creating a new mapping if needed. If a new
+// mapping is needed, but all ports of the NAT are in use, false is returned.
+// The caller must hold the lock of the network.
+func (nat *NAT) outbound(private netip.AddrPort, dest netip.AddrPort) (netip.AddrPort, bool) {
+	key := natKey{private: private}
+	if nat.typ == NATSymmetric {
+		key.dest = dest
+	}
+
+	m, ok := nat.mappings[key]
+	if !ok {
+		public, ok := nat.allocPort()
+		if !ok {
+			return netip.AddrPort{}, false
+		}
+		m = &natMapping{
+			private: private,
+			public:  public,
+			allowed: make(map[netip.AddrPort]struct{}),
+		}
+		nat.mappings[key] = m
+		nat.reverse[m.public] = m
+	}
+
+	m.allowed[dest] = struct{}{}
+	return m.public, true
+}
+
+// allocPort returns the next public address with a port that isn't used by any
+// mapping yet, or false if all ports are in use. The caller must hold the lock
+// of the network.
+func (nat *NAT) allocPort() (netip.AddrPort, bool) {
+	for i := 0; i < 0xffff; i++ {
+		nat.nextPort++
+		if nat.nextPort == 0 {
+			continue
+		}
+
+		public := netip.AddrPortFrom(nat.publicIP, nat.nextPort)
+		if _, ok := nat.reverse[public]; !ok {
+			return public, true
+		}
+	}
+
+	return netip.AddrPort{}, false
+}
+
+// inbound returns the endpoint a packet from the given source to the given
+// public address should be delivered to, or nil if the NAT doesn't let the
+// packet through. The caller must hold the lock of the network.
+func (nat *NAT) inbound(public netip.AddrPort, src netip.AddrPort) *Endpoint {
+	m, ok := nat.reverse[public]
+	if !ok {
+		return nil
+	}
+
+	switch nat.typ {
+	case NATFullCone:
+	case NATRestrictedCone:
+		ok = false
+		for dest := range m.allowed {
+			if dest.Addr() == src.Addr() {
+				ok = true
+				break
+			}
+		}
+	default:
+		_, ok = m.allowed[src]
+	}
+	if !ok {
+		return nil
+	}
+
+	return nat.endpoints[m.private]
+}
@@ -0,0 +1,451 @@
+// Package simnet implements an in-memory network of virtual transports, for
+// testing protocol logic without real sockets.
+//
+// Endpoints are connected by a Network, which acts as a switch between them.
+// The Network can simulate latency, packet loss, reordering and duplication.
+// Endpoints can also be placed behind a NAT. All random decisions are made with
+// a seeded random number generator, in the order in which packets are sent.
+//
+// By default, packets are delivered on the real clock and handled by the
+// goroutines that run Listen, so runs with concurrent senders can't be
+// reproduced. In manual mode, the network runs on a virtual clock instead.
+// Packets are only delivered when Step or Run is called, in the order of their
+// delivery time and then in the order in which they were sent, and they're
+// handled on the goroutine of the caller. As long as packets are only sent from
+// packet handlers and from that goroutine, the same seed results in the same
+// decisions and the same order of delivery.
+//
+// Endpoints implement transport.Transport and use *net.UDPAddr addresses with
+// virtual IPs, so any logic that runs on top of a UDPTransport can run on top of
+// an Endpoint as well.
+package simnet
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexbakker/tox4go/transport"
+)
+
+const (
+	// DefaultPort is the port that is assigned to every public endpoint.
+	DefaultPort = 33445
+
+	// inboxSize is the amount of packets that can wait to be handled by an
+	// endpoint. Packets that arrive while the inbox is full are dropped.
+	inboxSize = 1024
+)
+
+// ErrUnreachable is returned when sending a packet to an address that doesn't
+// belong to any endpoint or NAT in the network.
+var ErrUnreachable = errors.New("address unreachable")
+
+// Conditions describes the conditions of the links between endpoints.
+type Conditions struct {
+	// Latency is the amount of time it takes for a packet to arrive.
+	Latency time.Duration
+	// Jitter is the maximum amount of random extra latency that is added to a
+	// packet. Packets are reordered if the jitter is larger than the amount of
+	// time between them.
+	Jitter time.Duration
+	// LossRate is the probability that a packet is dropped, in the range
+	// [0, 1].
+	LossRate float64
+	// DuplicateRate is the probability that a packet is delivered twice, in
+	// the range [0, 1].
+	DuplicateRate float64
+}
+
+type Options struct {
+	// Seed is the seed for the random number generator of the network. In
+	// manual mode, runs with the same seed are reproducible, see the package
+	// documentation.
+	Seed int64
+	// Conditions are the initial link conditions of the network.
+	Conditions Conditions
+	// Manual makes the network run on a virtual clock. Packets are queued
+	// until they are delivered by Step or Run.
+	Manual bool
+}
+
+// Stats contains packet counters of a network.
+type Stats struct {
+	// Sent is the amount of packets that were sent by endpoints.
+	Sent uint64
+	// Delivered is the amount of packets that were delivered to an endpoint,
+	// including duplicates.
+	Delivered uint64
+	// Dropped is the amount of packets that were dropped because of packet
+	// loss, a NAT, or because the inbox of the destination was full or closed.
+	Dropped uint64
+	// Duplicated is the amount of packets that were duplicated.
+	Duplicated uint64
+}
+
+// Network is an in-process switch that connects virtual endpoints. It is safe
+// for concurrent use.
+type Network struct {
+	mu         sync.Mutex
+	rand       *rand.Rand
+	conditions Conditions
+	nextIP     netip.Addr
+	endpoints  map[netip.AddrPort]*Endpoint
+	nats       map[netip.Addr]*NAT
+
+	// The virtual clock and the packets that are waiting to be delivered by
+	// Step, for networks in manual mode.
+	manual bool
+	now    time.Duration
+	seq    uint64
+	queue  scheduleQueue
+
+	sent       atomic.Uint64
+	delivered  atomic.Uint64
+	dropped    atomic.Uint64
+	duplicated atomic.Uint64
+}
+
+// Endpoint is a virtual transport that is connected to a Network.
+type Endpoint struct {
+	network *Network
+	nat     *NAT
+	addr    netip.AddrPort
+	handler transport.PacketHandler
+
+	inbox    chan packet
+	stopChan chan struct{}
+	mu       sync.RWMutex
+	wg       sync.WaitGroup
+}
+
+type packet struct {
+	data []byte
+	from netip.AddrPort
+}
+
+// scheduledPacket is a packet that is waiting to be delivered by Step.
+type scheduledPacket struct {
+	packet
+	dst *Endpoint
+	at  time.Duration
+	seq uint64
+}
+
+// scheduleQueue is a min-heap of scheduled packets, ordered by delivery time
+// and then by the order in which they were scheduled.
+type scheduleQueue []*scheduledPacket
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q scheduleQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *scheduleQueue) Push(x any) { *q = append(*q, x.(*scheduledPacket)) }
+
+func (q *scheduleQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return p
+}
+
+// New creates a new network without any endpoints.
+func New(opts Options) *Network {
+	return &Network{
+		rand:       rand.New(rand.NewSource(opts.Seed)),
+		conditions: opts.Conditions,
+		manual:     opts.Manual,
+		nextIP:     netip.AddrFrom4([4]byte{10, 0, 0, 0}),
+		endpoints:  make(map[netip.AddrPort]*Endpoint),
+		nats:       make(map[netip.Addr]*NAT),
+	}
+}
+
+// SetConditions changes the link conditions of the network. It only affects
+// packets that are sent afterwards.
+func (n *Network) SetConditions(c Conditions) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.conditions = c
+}
+
+// Stats returns the packet counters of the network.
+func (n *Network) Stats() Stats {
+	return Stats{
+		Sent:       n.sent.Load(),
+		Delivered:  n.delivered.Load(),
+		Dropped:    n.dropped.Load(),
+		Duplicated: n.duplicated.Load(),
+	}
+}
+
+// NewEndpoint creates a new endpoint with a public address of its own. Packets
+// that arrive at the endpoint are passed to the given handler.
+func (n *Network) NewEndpoint(handler transport.PacketHandler) *Endpoint {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	e := newEndpoint(n, nil, netip.AddrPortFrom(n.allocIP(), DefaultPort), handler)
+	n.endpoints[e.addr] = e
+	return e
+}
+
+// allocIP returns the next unused public IP address. The caller must hold the
+// lock of the network.
+func (n *Network) allocIP() netip.Addr {
+	n.nextIP = n.nextIP.Next()
+	return n.nextIP
+}
+
+// send routes a packet from the given endpoint to the given address.
+func (n *Network) send(from *Endpoint, data []byte, to netip.AddrPort) error {
+	n.sent.Add(1)
+
+	n.mu.Lock()
+	src := from.addr
+	mapped := true
+	if from.nat != nil {
+		src, mapped = from.nat.outbound(from.addr, to)
+	}
+
+	var dst *Endpoint
+	var err error
+	if nat, ok := n.nats[to.Addr()]; ok {
+		dst = nat.inbound(to, src)
+	} else if dst, ok = n.endpoints[to]; !ok {
+		err = ErrUnreachable
+	}
+
+	c := n.conditions
+	lost := n.rand.Float64() < c.LossRate
+	copies := 1
+	if n.rand.Float64() < c.DuplicateRate {
+		copies++
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = c.Latency
+		if c.Jitter > 0 {
+			delays[i] += time.Duration(n.rand.Int63n(int64(c.Jitter)))
+		}
+	}
+	n.mu.Unlock()
+
+	if err != nil {
+		n.dropped.Add(1)
+		return err
+	}
+	if !mapped || dst == nil || lost {
+		n.dropped.Add(1)
+		return nil
+	}
+	if copies > 1 {
+		n.duplicated.Add(1)
+	}
+
+	p := packet{data: append([]byte(nil), data...), from: src}
+	if n.manual {
+		n.schedule(dst, p, delays)
+		return nil
+	}
+
+	for _, delay := range delays {
+		if delay <= 0 {
+			n.deliver(dst, p)
+			continue
+		}
+
+		time.AfterFunc(delay, func() {
+			n.deliver(dst, p)
+		})
+	}
+
+	return nil
+}
+
+// schedule queues a copy of the given packet for every delay, to be delivered
+// by Step.
+func (n *Network) schedule(dst *Endpoint, p packet, delays []time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, delay := range delays {
+		n.seq++
+		heap.Push(&n.queue, &scheduledPacket{
+			packet: p,
+			dst:    dst,
+			at:     n.now + max(delay, 0),
+			seq:    n.seq,
+		})
+	}
+}
+
+// Now returns the amount of virtual time that has passed on a network in manual
+// mode. It's the delivery time of the last packet that was delivered by Step.
+func (n *Network) Now() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.now
+}
+
+// Step advances the virtual clock of a network in manual mode to the delivery
+// time of the next packet, and passes that packet to the packet handler of its
+// destination. The handler is called on the goroutine of the caller, and any
+// packets it sends are queued as well. Step returns false if there are no
+// packets left to deliver.
+func (n *Network) Step() bool {
+	n.mu.Lock()
+	if n.queue.Len() == 0 {
+		n.mu.Unlock()
+		return false
+	}
+	p := heap.Pop(&n.queue).(*scheduledPacket)
+	n.now = p.at
+	n.mu.Unlock()
+
+	if p.dst.isStopped() {
+		n.dropped.Add(1)
+		return true
+	}
+
+	n.delivered.Add(1)
+	p.dst.HandlePacket(p.data, net.UDPAddrFromAddrPort(p.from))
+	return true
+}
+
+// Run calls Step until there are no packets left to deliver and returns the
+// amount of steps it took. It doesn't return if the packet handlers keep
+// sending packets to each other.
+func (n *Network) Run() int {
+	var steps int
+	for n.Step() {
+		steps++
+	}
+
+	return steps
+}
+
+func (n *Network) deliver(e *Endpoint, p packet) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.isStopped() {
+		n.dropped.Add(1)
+		return
+	}
+
+	select {
+	case e.inbox <- p:
+		n.delivered.Add(1)
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+func newEndpoint(n *Network, nat *NAT, addr netip.AddrPort, handler transport.PacketHandler) *Endpoint {
+	return &Endpoint{
+		network:  n,
+		nat:      nat,
+		addr:     addr,
+		handler:  handler,
+		inbox:    make(chan packet, inboxSize),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// LocalAddr returns the address of the endpoint. For endpoints behind a NAT,
+// this is their private address.
+func (e *Endpoint) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(e.addr)
+}
+
+func (e *Endpoint) SendPacket(data []byte, addr net.Addr) error {
+	if e.isStopped() {
+		return net.ErrClosed
+	}
+
+	addrPort, err := transport.AddrPort(addr)
+	if err != nil {
+		return err
+	}
+
+	return e.network.send(e, data, addrPort)
+}
+
+func (e *Endpoint) HandlePacket(data []byte, addr net.Addr) {
+	e.handler(data, addr)
+}
+
+// Listen passes packets that arrive at the endpoint to the packet handler until
+// the given context is canceled or the endpoint is closed, in which case nil is
+// returned. In manual mode, packets are passed to the packet handler by Step
+// instead, and Listen doesn't have to be called.
+func (e *Endpoint) Listen(ctx context.Context) error {
+	e.mu.Lock()
+	if e.isStopped() {
+		e.mu.Unlock()
+		return net.ErrClosed
+	}
+	e.wg.Add(1)
+	e.mu.Unlock()
+	defer e.wg.Done()
+
+	for {
+		select {
+		case p := <-e.inbox:
+			e.HandlePacket(p.data, net.UDPAddrFromAddrPort(p.from))
+		case <-ctx.Done():
+			return nil
+		case <-e.stopChan:
+			return nil
+		}
+	}
+}
+
+// Close disconnects the endpoint from the network and waits for the packet
+// handler to return.
+func (e *Endpoint) Close() error {
+	e.mu.Lock()
+	if e.isStopped() {
+		e.mu.Unlock()
+		return net.ErrClosed
+	}
+	close(e.stopChan)
+	e.mu.Unlock()
+
+	e.network.mu.Lock()
+	if e.nat != nil {
+		delete(e.nat.endpoints, e.addr)
+	} else {
+		delete(e.network.endpoints, e.addr)
+	}
+	e.network.mu.Unlock()
+
+	e.wg.Wait()
+	return nil
+}
+
+func (e *Endpoint) isStopped() bool {
+	select {
+	case <-e.stopChan:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,279 @@
+package simnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alexbakker/tox4go/transport"
+)
+
+var _ transport.Transport = (*Endpoint)(nil)
+
+type received struct {
+	data []byte
+	addr net.Addr
+}
+
+func newTestEndpoint(t *testing.T, newEndpoint func(transport.PacketHandler) *Endpoint) (*Endpoint, <-chan received) {
+	ch := make(chan received, inboxSize)
+	e := newEndpoint(func(data []byte, addr net.Addr) {
+		ch <- received{data: append([]byte(nil), data...), addr: addr}
+	})
+	go e.Listen(context.Background())
+	t.Cleanup(func() { e.Close() })
+	return e, ch
+}
+
+func expectPacket(t *testing.T, ch <-chan received) received {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for packet")
+		return received{}
+	}
+}
+
+func expectNoPacket(t *testing.T, ch <-chan received) {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected packet from %s", r.addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNetworkConditions(t *testing.T) {
+	n := New(Options{Seed: 1})
+	a, _ := newTestEndpoint(t, n.NewEndpoint)
+	b, bch := newTestEndpoint(t, n.NewEndpoint)
+
+	if err := a.SendPacket([]byte{0x01}, b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if r := expectPacket(t, bch); r.addr.String() != a.LocalAddr().String() {
+		t.Fatalf("bad source address: expected: %s, actual: %s", a.LocalAddr(), r.addr)
+	}
+
+	n.SetConditions(Conditions{LossRate: 1})
+	if err := a.SendPacket([]byte{0x02}, b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	expectNoPacket(t, bch)
+
+	n.SetConditions(Conditions{DuplicateRate: 1, Latency: 10 * time.Millisecond})
+	if err := a.SendPacket([]byte{0x03}, b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	expectPacket(t, bch)
+	expectPacket(t, bch)
+
+	stats := n.Stats()
+	if stats.Sent != 3 || stats.Delivered != 3 || stats.Dropped != 1 || stats.Duplicated != 1 {
+		t.Fatalf("bad stats: %+v", stats)
+	}
+
+	if err := a.SendPacket([]byte{0x04}, &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: DefaultPort}); err != ErrUnreachable {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrUnreachable, err)
+	}
+}
+
+func TestNetworkReorder(t *testing.T) {
+	n := New(Options{Seed: 1, Conditions: Conditions{Jitter: 20 * time.Millisecond}})
+	a, _ := newTestEndpoint(t, n.NewEndpoint)
+	b, bch := newTestEndpoint(t, n.NewEndpoint)
+
+	const count = 50
+	for i := 0; i < count; i++ {
+		if err := a.SendPacket([]byte{byte(i)}, b.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reordered := false
+	for i := 0; i < count; i++ {
+		if r := expectPacket(t, bch); int(r.data[0]) != i {
+			reordered = true
+		}
+	}
+	if !reordered {
+		t.Fatal("packets were not reordered")
+	}
+}
+
+func TestNetworkManual(t *testing.T) {
+	// runTrace runs a network in manual mode in which every packet with a
+	// non-zero counter is answered with two packets with a lower counter, and
+	// returns the order in which the packets were delivered
+	runTrace := func(seed int64) []string {
+		n := New(Options{
+			Seed:   seed,
+			Manual: true,
+			Conditions: Conditions{
+				Latency:       10 * time.Millisecond,
+				Jitter:        20 * time.Millisecond,
+				LossRate:      0.1,
+				DuplicateRate: 0.1,
+			},
+		})
+
+		var trace []string
+		var last time.Duration
+		endpoints := make([]*Endpoint, 3)
+		for i := range endpoints {
+			i := i
+			endpoints[i] = n.NewEndpoint(func(data []byte, addr net.Addr) {
+				now := n.Now()
+				if now < last {
+					t.Fatalf("packet delivered in the past: %s < %s", now, last)
+				}
+				last = now
+
+				trace = append(trace, fmt.Sprintf("%s: %s -> %s: %d", now, addr, endpoints[i].LocalAddr(), data[0]))
+				if data[0] == 0 {
+					return
+				}
+
+				next := endpoints[(i+1)%len(endpoints)]
+				for _, to := range []net.Addr{addr, next.LocalAddr()} {
+					if err := endpoints[i].SendPacket([]byte{data[0] - 1}, to); err != nil {
+						t.Fatal(err)
+					}
+				}
+			})
+			defer endpoints[i].Close()
+		}
+
+		if err := endpoints[0].SendPacket([]byte{6}, endpoints[1].LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if steps := n.Run(); steps != len(trace) {
+			t.Fatalf("bad step count: expected: %d, actual: %d", len(trace), steps)
+		}
+		if n.Step() {
+			t.Fatal("packet delivered after Run returned")
+		}
+
+		return trace
+	}
+
+	trace := runTrace(1)
+	if len(trace) < 10 {
+		t.Fatalf("bad trace length: %d", len(trace))
+	}
+	if other := runTrace(1); !reflect.DeepEqual(trace, other) {
+		t.Fatalf("runs with the same seed delivered packets differently:\n%v\n%v", trace, other)
+	}
+	if other := runTrace(2); reflect.DeepEqual(trace, other) {
+		t.Fatal("runs with different seeds delivered packets in the same way")
+	}
+}
+
+func TestNAT(t *testing.T) {
+	tests := []struct {
+		Type NATType
+		// Whether a third party can reach the mapping that was created
+		// for another host, from the same IP address and from a
+		// different one
+		SameIP  bool
+		OtherIP bool
+	}{
+		{Type: NATFullCone, SameIP: true, OtherIP: true},
+		{Type: NATRestrictedCone, SameIP: true, OtherIP: false},
+		{Type: NATPortRestrictedCone, SameIP: false, OtherIP: false},
+		{Type: NATSymmetric, SameIP: false, OtherIP: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Type.String(), func(t *testing.T) {
+			n := New(Options{})
+			nat := n.NewNAT(test.Type)
+			private, privateCh := newTestEndpoint(t, nat.NewEndpoint)
+			public, publicCh := newTestEndpoint(t, n.NewEndpoint)
+			other, _ := newTestEndpoint(t, n.NewEndpoint)
+
+			// The private endpoint can't be reached before it has sent anything
+			if err := public.SendPacket([]byte{0x01}, private.LocalAddr()); err != ErrUnreachable {
+				t.Fatalf("bad error: expected: %v, actual: %v", ErrUnreachable, err)
+			}
+
+			if err := private.SendPacket([]byte{0x02}, public.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			mapped := expectPacket(t, publicCh).addr.(*net.UDPAddr)
+			if mapped.AddrPort().Addr() != nat.PublicIP() {
+				t.Fatalf("bad mapped address: expected: %s, actual: %s", nat.PublicIP(), mapped)
+			}
+
+			// Replies to the mapped address are let through
+			if err := public.SendPacket([]byte{0x03}, mapped); err != nil {
+				t.Fatal(err)
+			}
+			expectPacket(t, privateCh)
+
+			// Test a different port on the same IP address
+			sameIP := newEndpoint(n, nil, netip.AddrPortFrom(public.addr.Addr(), DefaultPort+1), func([]byte, net.Addr) {})
+			if err := n.send(sameIP, []byte{0x04}, mapped.AddrPort()); err != nil {
+				t.Fatal(err)
+			}
+			if test.SameIP {
+				expectPacket(t, privateCh)
+			} else {
+				expectNoPacket(t, privateCh)
+			}
+
+			if err := other.SendPacket([]byte{0x05}, mapped); err != nil {
+				t.Fatal(err)
+			}
+			if test.OtherIP {
+				expectPacket(t, privateCh)
+			} else {
+				expectNoPacket(t, privateCh)
+			}
+		})
+	}
+}
+
+func TestNATPortExhaustion(t *testing.T) {
+	n := New(Options{})
+	nat := n.NewNAT(NATSymmetric)
+	private := netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 168, 0, 2}), DefaultPort)
+	dest := func(i int) netip.AddrPort {
+		return netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, 1, byte(i >> 8), byte(i)}), DefaultPort)
+	}
+
+	// Ports that are in use are skipped after the port number wraps around
+	nat.nextPort = 0xfffe
+	nat.reverse[netip.AddrPortFrom(nat.publicIP, 1)] = &natMapping{}
+	for i, expected := range []uint16{0xffff, 2} {
+		public, ok := nat.outbound(private, dest(i))
+		if !ok {
+			t.Fatal("no port was allocated")
+		}
+		if public.Port() != expected {
+			t.Fatalf("bad port: expected: %d, actual: %d", expected, public.Port())
+		}
+	}
+
+	for i := 2; len(nat.reverse) < 0xffff; i++ {
+		if _, ok := nat.outbound(private, dest(i)); !ok {
+			t.Fatalf("no port was allocated with %d ports in use", len(nat.reverse))
+		}
+	}
+	if _, ok := nat.outbound(private, dest(0xffff)); ok {
+		t.Fatal("port was allocated while all ports are in use")
+	}
+
+	// Existing mappings keep working
+	if public, ok := nat.outbound(private, dest(0)); !ok || public.Port() != 0xffff {
+		t.Fatalf("bad existing mapping: %s", public)
+	}
+}
@@ -1,11 +1,49 @@
 package transport
 
-import "net"
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
 
+const (
+	// maxTCPPacketSize is the maximum size of a single packet sent over TCP.
+	maxTCPPacketSize = 2048
+)
+
+// TCPTransport is a transport that sends and receives Tox packets over TCP.
+// Every packet is prefixed with its length as a big-endian uint16. Connections
+// are either accepted by the listener or dialed on demand when sending a packet
+// to an address we're not connected to yet. Packet handlers are given the
+// *net.TCPAddr of the connection a packet was received on.
 type TCPTransport struct {
 	listener *net.TCPListener
 	stopChan chan struct{}
+
+	mu       sync.RWMutex
 	handlers map[byte][]PacketHandler
+	conns    map[netip.AddrPort]*tcpConn
+	dials    map[netip.AddrPort]*tcpDial
+	wg       sync.WaitGroup
+}
+
+type tcpConn struct {
+	conn *net.TCPConn
+	mu   sync.Mutex
+}
+
+// tcpDial is a connection attempt that is in progress. Concurrent senders to
+// the same address wait for it instead of dialing a connection of their own.
+type tcpDial struct {
+	done chan struct{}
+	conn *tcpConn
+	err  error
 }
 
 func NewTCPTransport(netProto string, addr string) (*TCPTransport, error) {
@@ -23,5 +61,255 @@ func NewTCPTransport(netProto string, addr string) (*TCPTransport, error) {
 		listener: listener,
 		stopChan: make(chan struct{}),
 		handlers: map[byte][]PacketHandler{},
+		conns:    map[netip.AddrPort]*tcpConn{},
+		dials:    map[netip.AddrPort]*tcpDial{},
 	}, nil
 }
+
+// LocalAddr returns the local address the transport is listening on.
+func (t *TCPTransport) LocalAddr() net.Addr {
+	return t.listener.Addr()
+}
+
+// AddHandler registers a handler for packets of the given type. The type of a
+// packet is its first byte. Multiple handlers can be registered for the same
+// packet type.
+func (t *TCPTransport) AddHandler(packetType byte, handler PacketHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handlers[packetType] = append(t.handlers[packetType], handler)
+}
+
+func (t *TCPTransport) SendPacket(data []byte, addr net.Addr) error {
+	if len(data) > maxTCPPacketSize {
+		return fmt.Errorf("packet too large: %d > %d", len(data), maxTCPPacketSize)
+	}
+
+	addrPort, err := AddrPort(addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := t.getConn(addrPort)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	_, err = conn.conn.Write(buf)
+	return err
+}
+
+func (t *TCPTransport) HandlePacket(data []byte, addr net.Addr) {
+	t.mu.RLock()
+	handlers := t.handlers[data[0]]
+	t.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(data, addr)
+	}
+}
+
+// Listen accepts incoming connections until the given context is canceled or
+// the transport is shut down, in which case nil is returned. Connections that
+// were already established are not affected by the cancellation of the
+// context.
+func (t *TCPTransport) Listen(ctx context.Context) error {
+	t.mu.Lock()
+	if t.isStopped() {
+		t.mu.Unlock()
+		return net.ErrClosed
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+	defer t.wg.Done()
+
+	// Clear any deadline left behind by a previous call to Listen. Setting a
+	// deadline in the past interrupts the pending accept.
+	if err := t.listener.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = t.listener.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		conn, err := t.listener.AcceptTCP()
+		if err != nil {
+			if ctx.Err() != nil || t.isStopped() {
+				return nil
+			}
+			return err
+		}
+
+		if _, err = t.addConn(conn); err != nil {
+			conn.Close()
+			if t.isStopped() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Shutdown stops the transport. It stops accepting connections and receiving
+// packets, and waits for the packet handlers to return for any packets that
+// were already received, before closing the listener and all connections. If
+// the given context expires before that, everything is closed anyway and the
+// context's error is returned. Shutdown must not be called from within a
+// packet handler.
+func (t *TCPTransport) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.isStopped() {
+		t.mu.Unlock()
+		return net.ErrClosed
+	}
+	close(t.stopChan)
+
+	now := time.Now()
+	_ = t.listener.SetDeadline(now)
+	for _, conn := range t.conns {
+		_ = conn.conn.SetReadDeadline(now)
+	}
+	t.mu.Unlock()
+
+	waitErr := waitContext(ctx, &t.wg)
+
+	t.mu.Lock()
+	err := t.listener.Close()
+	for _, conn := range t.conns {
+		conn.conn.Close()
+	}
+	t.mu.Unlock()
+
+	return errors.Join(waitErr, err)
+}
+
+// Close shuts down the transport without a deadline. See Shutdown.
+func (t *TCPTransport) Close() error {
+	return t.Shutdown(context.Background())
+}
+
+func (t *TCPTransport) isStopped() bool {
+	select {
+	case <-t.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// getConn returns the connection to the given address. If there is no such
+// connection yet, a new one is dialed. Only one connection is dialed per
+// address at a time.
+func (t *TCPTransport) getConn(addr netip.AddrPort) (*tcpConn, error) {
+	t.mu.Lock()
+	if conn, ok := t.conns[addr]; ok {
+		t.mu.Unlock()
+		return conn, nil
+	}
+	if dial, ok := t.dials[addr]; ok {
+		t.mu.Unlock()
+		<-dial.done
+		return dial.conn, dial.err
+	}
+	dial := &tcpDial{done: make(chan struct{})}
+	t.dials[addr] = dial
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.dials, addr)
+		t.mu.Unlock()
+		close(dial.done)
+	}()
+
+	netConn, err := net.DialTCP("tcp", nil, net.TCPAddrFromAddrPort(addr))
+	if err != nil {
+		dial.err = err
+		return nil, err
+	}
+
+	dial.conn, dial.err = t.addConn(netConn)
+	if dial.err != nil {
+		netConn.Close()
+	}
+	return dial.conn, dial.err
+}
+
+// addConn registers the given connection and starts reading packets from it.
+// If we're already connected to the remote address, the new connection
+// replaces the old one. The returned connection may already have been removed
+// again by the time it is used, in which case writing to it fails.
+func (t *TCPTransport) addConn(conn *net.TCPConn) (*tcpConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.isStopped() {
+		return nil, net.ErrClosed
+	}
+
+	key, err := AddrPort(conn.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+	if old, ok := t.conns[key]; ok {
+		old.conn.Close()
+	}
+
+	c := &tcpConn{conn: conn}
+	t.conns[key] = c
+
+	t.wg.Add(1)
+	go t.readLoop(c)
+	return c, nil
+}
+
+// readLoop reads length-prefixed packets from the given connection until it is
+// closed or an error occurs.
+func (t *TCPTransport) readLoop(c *tcpConn) {
+	defer t.wg.Done()
+	defer t.removeConn(c)
+
+	addr := c.conn.RemoteAddr()
+	buf := make([]byte, maxTCPPacketSize)
+	for {
+		var length uint16
+		if err := binary.Read(c.conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+
+		if length > maxTCPPacketSize {
+			return
+		}
+
+		if _, err := io.ReadFull(c.conn, buf[:length]); err != nil {
+			return
+		}
+
+		if length < 1 {
+			continue
+		}
+
+		t.HandlePacket(buf[:length], addr)
+	}
+}
+
+func (t *TCPTransport) removeConn(c *tcpConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c.conn.Close()
+	key, _ := AddrPort(c.conn.RemoteAddr())
+	if t.conns[key] == c {
+		delete(t.conns, key)
+	}
+}
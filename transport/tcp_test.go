@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTCPTransport(t *testing.T) *TCPTransport {
+	tr, err := NewTCPTransport("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go tr.Listen(context.Background())
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestTCPTransport(t *testing.T) {
+	var _ Transport = (*TCPTransport)(nil)
+
+	type packet struct {
+		data []byte
+		addr net.Addr
+	}
+
+	tr1 := newTestTCPTransport(t)
+	tr2 := newTestTCPTransport(t)
+
+	packets := make(chan packet, 1)
+	tr2.AddHandler(0x02, func(data []byte, addr net.Addr) {
+		packets <- packet{data: append([]byte(nil), data...), addr: addr}
+	})
+	tr1.AddHandler(0x04, func(data []byte, addr net.Addr) {
+		packets <- packet{data: append([]byte(nil), data...), addr: addr}
+	})
+
+	unixAddr := &net.UnixAddr{Name: "tox", Net: "unix"}
+	if err := tr1.SendPacket([]byte{0x02}, unixAddr); !errors.Is(err, ErrUnsupportedAddr) {
+		t.Fatalf("bad error: expected: %v, actual: %v", ErrUnsupportedAddr, err)
+	}
+
+	req := []byte{0x02, 1, 2, 3}
+	if err := tr1.SendPacket(req, tr2.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	var p packet
+	select {
+	case p = <-packets:
+		if !bytes.Equal(p.data, req) {
+			t.Fatalf("bad packet: expected: %x, actual: %x", req, p.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for packet")
+	}
+
+	// Reply over the connection that was accepted by tr2
+	res := []byte{0x04, 4, 5, 6}
+	if err := tr2.SendPacket(res, p.addr); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p = <-packets:
+		if !bytes.Equal(p.data, res) {
+			t.Fatalf("bad packet: expected: %x, actual: %x", res, p.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for packet")
+	}
+}
+
+func TestTCPTransportConcurrentDial(t *testing.T) {
+	tr1 := newTestTCPTransport(t)
+	tr2 := newTestTCPTransport(t)
+
+	const count = 10
+	packets := make(chan net.Addr, count)
+	tr2.AddHandler(0x02, func(data []byte, addr net.Addr) {
+		packets <- addr
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tr1.SendPacket([]byte{0x02}, tr2.LocalAddr())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// All packets must arrive over the same connection
+	var addr net.Addr
+	for i := 0; i < count; i++ {
+		select {
+		case p := <-packets:
+			if addr == nil {
+				addr = p
+			} else if p.String() != addr.String() {
+				t.Fatalf("bad address: expected: %s, actual: %s", addr, p)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for packet")
+		}
+	}
+}
@@ -1,11 +1,26 @@
 package transport
 
-import "net"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
 
-// PacketHandler is a handler function for Tox packets. The backing buffer of
-// the given data slice may be reused after the function returns, potentially
-// overwriting the contents of the slice.
-type PacketHandler func(data []byte, addr *net.UDPAddr)
+// ErrUnsupportedAddr is wrapped by the error that is returned when sending a
+// packet to an address of a type that the transport can't send packets to.
+var ErrUnsupportedAddr = errors.New("unsupported address")
+
+// PacketHandler is a handler function for Tox packets. The transport owns the
+// backing buffer of the given data slice. It is released as soon as the
+// function returns, after which it may be reused for other packets, potentially
+// overwriting the contents of the slice. Handlers must copy any data they want
+// to hold on to, unless the transport allows them to take ownership of the
+// buffer instead, like UDPTransport does with Retain. The type of the given
+// address depends on the transport the packet was received on.
+type PacketHandler func(data []byte, addr net.Addr)
 
 type Packet interface {
 	MarshalBinary() ([]byte, error)
@@ -13,9 +28,59 @@ type Packet interface {
 	ID() byte
 }
 
+// Transport sends and receives Tox packets. Peers are identified by a
+// net.Addr, which doesn't have to be an IP address and port: a transport that
+// reaches peers through a relay or in memory can use an address type of its
+// own. The transports in this package accept any address that can be converted
+// with AddrPort. Sending a packet to an address of a type that a transport
+// doesn't support results in an error that wraps ErrUnsupportedAddr.
 type Transport interface {
-	SendPacket(data []byte, addr *net.UDPAddr) error
-	HandlePacket(data []byte, addr *net.UDPAddr)
-	Listen() error
+	SendPacket(data []byte, addr net.Addr) error
+	HandlePacket(data []byte, addr net.Addr)
+	LocalAddr() net.Addr
+	// Listen receives packets until the given context is canceled or the
+	// transport is closed, in which case nil is returned.
+	Listen(ctx context.Context) error
+	// Close stops the transport and waits for in-flight packet handlers to
+	// return.
 	Close() error
 }
+
+// AddrPort returns the IP address and port of the given address. It supports
+// *net.UDPAddr, *net.TCPAddr and any other address type with an AddrPort
+// method. For any other address, an error that wraps ErrUnsupportedAddr is
+// returned.
+func AddrPort(addr net.Addr) (netip.AddrPort, error) {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return unmapAddrPort(addr.AddrPort()), nil
+	case *net.TCPAddr:
+		return unmapAddrPort(addr.AddrPort()), nil
+	case interface{ AddrPort() netip.AddrPort }:
+		return unmapAddrPort(addr.AddrPort()), nil
+	case nil:
+		return netip.AddrPort{}, fmt.Errorf("%w: nil address", ErrUnsupportedAddr)
+	}
+
+	return netip.AddrPort{}, fmt.Errorf("%w: %s address: %s", ErrUnsupportedAddr, addr.Network(), addr)
+}
+
+func unmapAddrPort(addrPort netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
+
+// waitContext waits for the given wait group until the given context expires.
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,17 +1,85 @@
 package transport
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxUDPPacketSize is the maximum size of a single packet sent or
+	// received over UDP.
+	maxUDPPacketSize = 2048
 )
 
 type UDPTransport struct {
 	conn     *net.UDPConn
 	stopChan chan struct{}
 	handler  PacketHandler
+	opts     UDPTransportOptions
+	bufPool  sync.Pool
+
+	// handling contains the buffers of the packets that are being handled,
+	// by the address of their first byte, so that they can be retained
+	handlingMu sync.Mutex
+	handling   map[*byte]*udpBuffer
+
+	// batch is only set if batched I/O is enabled
+	batch *udpBatch
+
+	mu sync.RWMutex
+	wg sync.WaitGroup
+}
+
+type UDPTransportOptions struct {
+	// Workers is the amount of goroutines that run the packet handler. If
+	// zero, the packet handler runs on the goroutine that calls Listen, so a
+	// slow handler stalls the reception of packets. If non-zero, every
+	// received packet is queued for the workers. The buffer of a packet is
+	// returned to the pool as soon as the packet handler returns, so handlers
+	// must copy any data they want to hold on to, or retain the buffer with
+	// Retain.
+	Workers int
+	// QueueSize is the amount of received packets that can wait for a worker
+	// to become available. Reception of packets blocks while the queue is
+	// full. If zero, four times the amount of workers is used.
+	QueueSize int
+	// BatchSize is the maximum amount of packets that are read or written
+	// with a single system call. If zero, batched I/O is disabled. See
+	// NewUDPTransport for the implications of enabling batched I/O.
+	BatchSize int
+}
+
+type udpPacket struct {
+	buf  *[]byte
+	n    int
+	addr *net.UDPAddr
 }
 
-func NewUDPTransport(netProto string, addr string, handler PacketHandler) (*UDPTransport, error) {
+// udpBuffer is the buffer of a packet that is being handled.
+type udpBuffer struct {
+	mu       sync.Mutex
+	buf      *[]byte
+	handled  bool
+	retained bool
+}
+
+// NewUDPTransport creates a new UDP transport that listens on the given
+// address.
+//
+// If batched I/O is enabled through the options, packets are read and written
+// in batches using recvmmsg and sendmmsg on platforms that support them, and one
+// at a time on other platforms. Packets passed to SendPacket are copied and
+// queued, so that they can be written together with other queued packets.
+// SendPacket only blocks while the send queue is full and does not report
+// errors that occur while writing a batch, just like it couldn't report a
+// packet getting lost on the way. Writes to IPv4 peers can only be batched if
+// the transport is bound to an IPv4 socket ("udp4"). On dual-stack sockets,
+// these writes fall back to one system call per packet.
+func NewUDPTransport(netProto string, addr string, handler PacketHandler, opts UDPTransportOptions) (*UDPTransport, error) {
 	udpAddr, err := net.ResolveUDPAddr(netProto, addr)
 	if err != nil {
 		return nil, err
@@ -22,43 +90,290 @@ func NewUDPTransport(netProto string, addr string, handler PacketHandler) (*UDPT
 		return nil, err
 	}
 
-	return &UDPTransport{
+	if opts.Workers > 0 && opts.QueueSize <= 0 {
+		opts.QueueSize = opts.Workers * 4
+	}
+
+	t := &UDPTransport{
 		conn:     conn,
 		stopChan: make(chan struct{}),
 		handler:  handler,
-	}, nil
+		opts:     opts,
+		handling: make(map[*byte]*udpBuffer),
+		bufPool: sync.Pool{
+			New: func() any {
+				buf := make([]byte, maxUDPPacketSize)
+				return &buf
+			},
+		},
+	}
+
+	if opts.BatchSize > 0 {
+		t.batch = newUDPBatch(netProto, conn, opts.BatchSize)
+		go t.writeBatches()
+	}
+
+	return t, nil
 }
 
-func (t *UDPTransport) SendPacket(data []byte, addr *net.UDPAddr) error {
-	_, err := t.conn.WriteTo(data, addr)
+// LocalAddr returns the local address the transport is listening on.
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *UDPTransport) SendPacket(data []byte, addr net.Addr) error {
+	if t.batch != nil {
+		return t.queuePacket(data, addr)
+	}
+
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		_, err := t.conn.WriteToUDP(data, udpAddr)
+		return err
+	}
+
+	addrPort, err := AddrPort(addr)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.conn.WriteToUDPAddrPort(data, addrPort)
 	return err
 }
 
-func (t *UDPTransport) HandlePacket(data []byte, addr *net.UDPAddr) {
+func (t *UDPTransport) HandlePacket(data []byte, addr net.Addr) {
 	t.handler(data, addr)
 }
 
-func (t *UDPTransport) Listen() error {
-	buf := make([]byte, 2048)
+// Retain takes ownership of the buffer of a packet that is being handled, so
+// that a packet handler can hold on to the data it was given without copying
+// it. It must be called by the packet handler with the exact data slice that
+// was passed to it, before the handler returns. The buffer is returned to the
+// pool of the transport once the returned release function is called, after
+// which the data must no longer be used. Calling release more than once has no
+// effect. If the given data doesn't belong to a packet that is being handled,
+// false is returned and the data must be copied instead.
+func (t *UDPTransport) Retain(data []byte) (release func(), ok bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	t.handlingMu.Lock()
+	b, ok := t.handling[&data[0]]
+	t.handlingMu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.handled || b.retained {
+		return nil, false
+	}
+	b.retained = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.bufPool.Put(b.buf)
+		})
+	}, true
+}
+
+// Listen receives packets and passes them to the packet handler until the
+// given context is canceled or the transport is shut down. In both cases, nil
+// is returned once the handler has returned for all received packets. Any
+// other error stops the listener and is returned. Listen may be called again
+// after the context passed to it was canceled, but not after the transport was
+// shut down.
+func (t *UDPTransport) Listen(ctx context.Context) error {
+	t.mu.Lock()
+	if t.isStopped() {
+		t.mu.Unlock()
+		return net.ErrClosed
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+	defer t.wg.Done()
+
+	// Clear any read deadline left behind by a previous call to Listen. Setting
+	// a read deadline in the past interrupts the pending read.
+	if err := t.conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = t.conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	dispatch, wait := t.newDispatcher()
+	defer wait()
+
+	var err error
+	if t.batch != nil {
+		err = t.readBatches(dispatch)
+	} else {
+		err = t.read(dispatch)
+	}
+
+	if ctx.Err() != nil || t.isStopped() {
+		return nil
+	}
+	return err
+}
+
+// read reads packets one at a time and passes them to the given dispatch
+// function until an error occurs.
+func (t *UDPTransport) read(dispatch func(p udpPacket)) error {
 	for {
-		read, senderAddr, err := t.conn.ReadFromUDP(buf)
+		buf := t.bufPool.Get().(*[]byte)
+		read, senderAddr, err := t.conn.ReadFromUDP(*buf)
 		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				close(t.stopChan)
-			}
+			t.bufPool.Put(buf)
 			return err
 		}
 
 		if read < 1 {
+			t.bufPool.Put(buf)
 			continue
 		}
 
-		t.HandlePacket(buf[:read], senderAddr)
+		dispatch(udpPacket{buf: buf, n: read, addr: senderAddr})
+	}
+}
+
+// newDispatcher returns a function that passes a received packet to the packet
+// handler and returns its buffer to the pool afterwards. If workers are
+// enabled, the packet is queued for the workers instead. The returned wait
+// function must be called once no more packets will be dispatched. It waits
+// for the packet handler to return for all dispatched packets.
+func (t *UDPTransport) newDispatcher() (dispatch func(p udpPacket), wait func()) {
+	handle := func(p udpPacket) {
+		data := (*p.buf)[:p.n]
+		b := &udpBuffer{buf: p.buf}
+		t.handlingMu.Lock()
+		t.handling[&data[0]] = b
+		t.handlingMu.Unlock()
+
+		t.HandlePacket(data, p.addr)
+
+		t.handlingMu.Lock()
+		delete(t.handling, &data[0])
+		t.handlingMu.Unlock()
+
+		b.mu.Lock()
+		b.handled = true
+		retained := b.retained
+		b.mu.Unlock()
+		if !retained {
+			t.bufPool.Put(p.buf)
+		}
+	}
+
+	if t.opts.Workers <= 0 {
+		return handle, func() {}
+	}
+
+	queue := make(chan udpPacket, t.opts.QueueSize)
+	var workers sync.WaitGroup
+	for i := 0; i < t.opts.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			for p := range queue {
+				handle(p)
+			}
+		}()
+	}
+
+	dispatch = func(p udpPacket) {
+		queue <- p
+	}
+	wait = func() {
+		close(queue)
+		workers.Wait()
+	}
+	return dispatch, wait
+}
+
+// queuePacket copies the given packet and queues it to be written in a batch.
+func (t *UDPTransport) queuePacket(data []byte, addr net.Addr) error {
+	if len(data) > maxUDPPacketSize {
+		return fmt.Errorf("packet too large: %d > %d", len(data), maxUDPPacketSize)
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		addrPort, err := AddrPort(addr)
+		if err != nil {
+			return err
+		}
+		udpAddr = net.UDPAddrFromAddrPort(addrPort)
+	}
+
+	buf := t.bufPool.Get().(*[]byte)
+	n := copy(*buf, data)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Packet handlers may still send packets while the transport is shutting
+	// down, so only the closing of the queue matters here
+	if t.batch.closed {
+		t.bufPool.Put(buf)
+		return net.ErrClosed
+	}
+
+	t.batch.queue <- udpPacket{buf: buf, n: n, addr: udpAddr}
+	return nil
+}
+
+// Shutdown stops the transport. It stops receiving packets and waits for the
+// packet handler to return for any packets that were already received, and
+// for any queued packets to be written, before closing the underlying
+// connection. If the given context expires before that, the connection is
+// closed anyway and the context's error is returned. Shutdown must not be
+// called from within a packet handler.
+func (t *UDPTransport) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.isStopped() {
+		t.mu.Unlock()
+		return net.ErrClosed
 	}
+	close(t.stopChan)
+	t.mu.Unlock()
+
+	_ = t.conn.SetReadDeadline(time.Now())
+	waitErr := waitContext(ctx, &t.wg)
+
+	if t.batch != nil {
+		t.mu.Lock()
+		t.batch.closed = true
+		close(t.batch.queue)
+		t.mu.Unlock()
+
+		if waitErr == nil {
+			select {
+			case <-t.batch.done:
+			case <-ctx.Done():
+				waitErr = ctx.Err()
+			}
+		}
+	}
+
+	return errors.Join(waitErr, t.conn.Close())
 }
 
+// Close shuts down the transport without a deadline. See Shutdown.
 func (t *UDPTransport) Close() error {
-	err := t.conn.Close()
-	<-t.stopChan
-	return err
+	return t.Shutdown(context.Background())
+}
+
+func (t *UDPTransport) isStopped() bool {
+	select {
+	case <-t.stopChan:
+		return true
+	default:
+		return false
+	}
 }
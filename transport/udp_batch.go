@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchConn is implemented by both ipv4.PacketConn and ipv6.PacketConn.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// udpBatch holds the state for batched I/O on a UDP socket.
+type udpBatch struct {
+	conn batchConn
+	size int
+	ipv4 bool
+
+	// queue holds the packets that are waiting to be written. It is closed
+	// when the transport shuts down, after which done is closed once all
+	// queued packets have been written.
+	queue  chan udpPacket
+	closed bool
+	done   chan struct{}
+}
+
+func newUDPBatch(netProto string, conn *net.UDPConn, size int) *udpBatch {
+	b := udpBatch{
+		size:  size,
+		queue: make(chan udpPacket, size*4),
+		done:  make(chan struct{}),
+	}
+
+	// The x/net packages marshal IPv4 addresses as AF_INET socket addresses,
+	// so IPv4 peers can only be written to in a batch on IPv4 sockets
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	switch {
+	case netProto == "udp4", netProto != "udp6" && localAddr.IP.To4() != nil:
+		b.conn = ipv4.NewPacketConn(conn)
+		b.ipv4 = true
+	default:
+		b.conn = ipv6.NewPacketConn(conn)
+	}
+
+	return &b
+}
+
+// readBatches reads packets in batches and passes them to the given dispatch
+// function until an error occurs.
+func (t *UDPTransport) readBatches(dispatch func(p udpPacket)) error {
+	msgs := make([]ipv4.Message, t.batch.size)
+	bufs := make([]*[]byte, t.batch.size)
+	for i := range msgs {
+		bufs[i] = t.bufPool.Get().(*[]byte)
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+	defer func() {
+		for _, buf := range bufs {
+			t.bufPool.Put(buf)
+		}
+	}()
+
+	for {
+		n, err := t.batch.conn.ReadBatch(msgs, 0)
+		if err != nil {
+			return err
+		}
+
+		for i, msg := range msgs[:n] {
+			if msg.N < 1 {
+				continue
+			}
+
+			addr, ok := msg.Addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			// Ownership of the buffer passes to the dispatcher, so replace it
+			// with a fresh one for the next batch
+			dispatch(udpPacket{buf: bufs[i], n: msg.N, addr: addr})
+			bufs[i] = t.bufPool.Get().(*[]byte)
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+// writeBatches writes the packets in the send queue in batches until the queue
+// is closed.
+func (t *UDPTransport) writeBatches() {
+	defer close(t.batch.done)
+
+	pending := make([]udpPacket, 0, t.batch.size)
+	msgs := make([]ipv4.Message, 0, t.batch.size)
+	for p := range t.batch.queue {
+		pending = append(pending[:0], p)
+
+		// Collect any other packets that are already waiting, without
+		// blocking
+	collect:
+		for len(pending) < t.batch.size {
+			select {
+			case p, ok := <-t.batch.queue:
+				if !ok {
+					break collect
+				}
+				pending = append(pending, p)
+			default:
+				break collect
+			}
+		}
+
+		msgs = msgs[:0]
+		for _, p := range pending {
+			data := (*p.buf)[:p.n]
+			if (p.addr.IP.To4() != nil) != t.batch.ipv4 {
+				// Errors are dropped, just like they are for the batch
+				_, _ = t.conn.WriteToUDP(data, p.addr)
+				continue
+			}
+
+			msgs = append(msgs, ipv4.Message{Buffers: [][]byte{data}, Addr: p.addr})
+		}
+
+		for sent := 0; sent < len(msgs); {
+			n, err := t.batch.conn.WriteBatch(msgs[sent:], 0)
+			if err != nil {
+				break
+			}
+			sent += n
+		}
+
+		for _, p := range pending {
+			t.bufPool.Put(p.buf)
+		}
+	}
+}
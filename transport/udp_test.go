@@ -0,0 +1,235 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestUDPTransport(t *testing.T, handler PacketHandler, opts UDPTransportOptions) *UDPTransport {
+	tr, err := NewUDPTransport("udp4", "127.0.0.1:0", handler, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr
+}
+
+func TestUDPTransportCloseWithoutListen(t *testing.T) {
+	tr := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, UDPTransportOptions{})
+
+	done := make(chan error)
+	go func() { done <- tr.Close() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for close")
+	}
+
+	if err := tr.Listen(context.Background()); err == nil {
+		t.Fatal("listen succeeded after close")
+	}
+}
+
+func TestUDPTransportListenCancel(t *testing.T) {
+	tr := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, UDPTransportOptions{})
+	defer tr.Close()
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- tr.Listen(ctx) }()
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for listen to return")
+		}
+	}
+}
+
+func TestUDPTransportShutdownDrain(t *testing.T) {
+	var handled atomic.Bool
+	started := make(chan struct{})
+	tr := newTestUDPTransport(t, func(data []byte, addr net.Addr) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		handled.Store(true)
+	}, UDPTransportOptions{})
+
+	go tr.Listen(context.Background())
+
+	sender := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, UDPTransportOptions{})
+	defer sender.Close()
+	if err := sender.SendPacket([]byte{0x00}, tr.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for packet")
+	}
+
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !handled.Load() {
+		t.Fatal("shutdown returned before the handler did")
+	}
+}
+
+func TestUDPTransportWorkers(t *testing.T) {
+	const count = 100
+
+	var handled atomic.Int32
+	release := make(chan struct{})
+	tr := newTestUDPTransport(t, func(data []byte, addr net.Addr) {
+		// Block all workers on the first packets, to make sure that they run
+		// concurrently and don't stall the listener
+		if data[0] < 4 {
+			<-release
+		}
+		handled.Add(1)
+	}, UDPTransportOptions{Workers: 4, QueueSize: count})
+
+	go tr.Listen(context.Background())
+
+	sender := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, UDPTransportOptions{})
+	defer sender.Close()
+	for i := 0; i < count; i++ {
+		if err := sender.SendPacket([]byte{byte(i)}, tr.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if i == 50 {
+			close(release)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for handled.Load() < count && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := handled.Load(); n != count {
+		t.Fatalf("bad amount of handled packets: expected: %d, actual: %d", count, n)
+	}
+}
+
+func TestUDPTransportBatch(t *testing.T) {
+	const count = 1000
+
+	var handled atomic.Int32
+	opts := UDPTransportOptions{Workers: 2, BatchSize: 32}
+	tr := newTestUDPTransport(t, func(data []byte, addr net.Addr) {
+		if len(data) != 2 || data[0] != 0x02 {
+			t.Errorf("bad packet: %x", data)
+		}
+		handled.Add(1)
+	}, opts)
+
+	go tr.Listen(context.Background())
+
+	sender := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, opts)
+	for i := 0; i < count; i++ {
+		if err := sender.SendPacket([]byte{0x02, byte(i)}, tr.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		// Give the receiver some time to keep up, so that the socket buffer
+		// doesn't overflow
+		if i%100 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Closing the sender flushes its send queue
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for handled.Load() < count && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := handled.Load(); n != count {
+		t.Fatalf("bad amount of handled packets: expected: %d, actual: %d", count, n)
+	}
+}
+
+func TestUDPTransportRetain(t *testing.T) {
+	const count = 10
+
+	var tr *UDPTransport
+	retained := make(chan []byte, count)
+	releases := make(chan func(), count)
+	tr = newTestUDPTransport(t, func(data []byte, addr net.Addr) {
+		if _, ok := tr.Retain(append([]byte(nil), data...)); ok {
+			t.Error("copy of the data was retained")
+		}
+
+		release, ok := tr.Retain(data)
+		if !ok {
+			t.Error("buffer was not retained")
+			return
+		}
+		if _, ok := tr.Retain(data); ok {
+			t.Error("buffer was retained twice")
+		}
+
+		retained <- data
+		releases <- release
+	}, UDPTransportOptions{Workers: 2})
+	defer tr.Close()
+
+	go tr.Listen(context.Background())
+
+	sender := newTestUDPTransport(t, func(data []byte, addr net.Addr) {}, UDPTransportOptions{})
+	defer sender.Close()
+	for i := 0; i < count; i++ {
+		if err := sender.SendPacket(bytes.Repeat([]byte{byte(i)}, 64), tr.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// None of the retained buffers may have been reused for other packets
+	var datas [][]byte
+	for i := 0; i < count; i++ {
+		select {
+		case data := <-retained:
+			datas = append(datas, data)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for packet")
+		}
+	}
+	seen := make(map[byte]bool)
+	for _, data := range datas {
+		if !bytes.Equal(data, bytes.Repeat(data[:1], 64)) || seen[data[0]] {
+			t.Fatalf("retained buffer was overwritten: %x", data)
+		}
+		seen[data[0]] = true
+	}
+
+	for i := 0; i < count; i++ {
+		release := <-releases
+		release()
+		release()
+	}
+}